@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"path/filepath"
+
+	"github.com/go-pluto/benchmark/resultlog"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// writeTable writes t in the supplied format
+// ("csv" or "markdown") to w.
+func writeTable(w io.Writer, t *resultlog.Table, format string) error {
+
+	switch format {
+	case "csv":
+		return t.WriteCSV(w)
+	case "markdown", "md":
+		return t.WriteMarkdown(w)
+	}
+
+	return fmt.Errorf("unknown output format '%s', choose csv or markdown", format)
+}
+
+// runAnalyze implements the 'analyze' subcommand. It loads
+// all supplied results files and writes the selected tables
+// either to stdout or, one file per table, to a directory.
+func runAnalyze(args []string) {
+
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	formatFlag := fs.String("format", "markdown", "Output format of the tables: csv or markdown.")
+	tablesFlag := fs.String("tables", "summary,timeseries,users,lengths,endpoints", "Comma separated list of tables to output.")
+	bucketFlag := fs.Duration("bucket", 10*time.Second, "Width of the buckets of the time series and soak tables.")
+	outFlag := fs.String("out", "", "Directory to write one file per table to instead of stdout.")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		glog.Fatal("analyze expects at least one results file")
+	}
+
+	runs := make([]resultlog.Run, 0, fs.NArg())
+
+	for _, path := range fs.Args() {
+
+		log, err := resultlog.Load(path)
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		runs = append(runs, resultlog.Run{
+			Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Log:  log,
+		})
+	}
+
+	var tables []*resultlog.Table
+
+	for _, name := range strings.Split(*tablesFlag, ",") {
+
+		switch strings.TrimSpace(name) {
+		case "summary":
+			tables = append(tables, resultlog.SummaryTable(runs))
+		case "timeseries":
+			tables = append(tables, resultlog.TimeSeriesTable(runs, *bucketFlag))
+		case "users":
+			tables = append(tables, resultlog.UserTable(runs))
+		case "lengths":
+			tables = append(tables, resultlog.SessionLengthTable(runs))
+		case "endpoints":
+			tables = append(tables, resultlog.EndpointTable(runs))
+		case "convergence":
+			tables = append(tables, resultlog.ConvergenceTable(runs))
+		case "connections":
+			tables = append(tables, resultlog.ConnectionTable(runs))
+		case "literals":
+			tables = append(tables, resultlog.LiteralTable(runs))
+		case "notifications":
+			tables = append(tables, resultlog.NotificationTable(runs))
+		case "soak":
+			tables = append(tables, resultlog.SoakTable(runs, *bucketFlag))
+		default:
+			glog.Fatalf("Unknown table '%s', choose from summary, timeseries, users, lengths, endpoints, convergence, connections, literals, notifications, soak", name)
+		}
+	}
+
+	ext := ".md"
+	if *formatFlag == "csv" {
+		ext = ".csv"
+	}
+
+	for i, t := range tables {
+
+		if *outFlag == "" {
+
+			if i > 0 {
+				fmt.Println()
+			}
+
+			err := writeTable(os.Stdout, t, *formatFlag)
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			continue
+		}
+
+		err := os.MkdirAll(*outFlag, 0755)
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		file, err := os.Create(filepath.Join(*outFlag, (t.Name + ext)))
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		err = writeTable(file, t, *formatFlag)
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		err = file.Close()
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+}
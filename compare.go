@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"text/tabwriter"
+
+	"github.com/go-pluto/benchmark/resultlog"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// verdict classifies a Delta as regression, improvement
+// or unchanged. A change only counts if it exceeds the
+// threshold and is significant at level alpha. Commands
+// sent in only one of the runs cannot be tested and are
+// reported as such.
+func verdict(d resultlog.Delta, threshold float64, alpha float64) string {
+
+	switch {
+	case d.New.Count == 0:
+		return "only in baseline"
+	case d.Base.Count == 0:
+		return "only in candidate"
+	}
+
+	latencySignificant := d.LatencyTest.P < alpha
+	throughputSignificant := d.ThroughputTest.P < alpha
+
+	switch {
+	case latencySignificant && (d.LatencyChange > threshold):
+		return "REGRESSION (latency)"
+	case throughputSignificant && (d.ThroughputChange < -threshold):
+		return "REGRESSION (throughput)"
+	case latencySignificant && (d.LatencyChange < -threshold):
+		return "improved (latency)"
+	case throughputSignificant && (d.ThroughputChange > threshold):
+		return "improved (throughput)"
+	}
+
+	return "unchanged"
+}
+
+// percent formats a relative change for output.
+func percent(change float64) string {
+
+	if math.IsNaN(change) {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%+.1f%%", (change * 100))
+}
+
+// runCompare implements the 'compare' subcommand. It loads a
+// base and a new results file, prints per-command latency and
+// throughput deltas and exits with status 1 if any command
+// regressed beyond the configured threshold.
+func runCompare(args []string) {
+
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	thresholdFlag := fs.Float64("threshold", 0.1, "Relative increase of median latency or decrease of throughput above which a significant change counts as regression.")
+	alphaFlag := fs.Float64("alpha", 0.05, "Significance level a change has to reach to be considered.")
+	bucketFlag := fs.Duration("bucket", time.Second, "Width of the time buckets throughput samples are taken in.")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		glog.Fatal("compare expects exactly two results files: <base> <new>")
+	}
+
+	base, err := resultlog.Load(fs.Arg(0))
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	candidate, err := resultlog.Load(fs.Arg(1))
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	deltas := resultlog.Compare(base, candidate, *bucketFlag)
+	regressed := false
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND\tN BASE\tN NEW\tP50 BASE (ms)\tP50 NEW (ms)\tP50 CHANGE\tP95 BASE (ms)\tP95 NEW (ms)\tP (LATENCY)\tTPUT BASE (1/s)\tTPUT NEW (1/s)\tTPUT CHANGE\tP (TPUT)\tVERDICT")
+
+	for _, d := range deltas {
+
+		v := verdict(d, *thresholdFlag, *alphaFlag)
+		if strings.HasPrefix(v, "REGRESSION") {
+			regressed = true
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.3f\t%.3f\t%s\t%.3f\t%.3f\t%.4f\t%.2f\t%.2f\t%s\t%.4f\t%s\n",
+			d.Command, d.Base.Count, d.New.Count,
+			d.Base.P50, d.New.P50, percent(d.LatencyChange),
+			d.Base.P95, d.New.P95, d.LatencyTest.P,
+			d.Base.Throughput, d.New.Throughput, percent(d.ThroughputChange), d.ThroughputTest.P,
+			v)
+	}
+
+	w.Flush()
+
+	if regressed {
+		fmt.Fprintf(os.Stderr, "Regression beyond threshold of %.1f%% detected.\n", (*thresholdFlag * 100))
+		os.Exit(1)
+	}
+}
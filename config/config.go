@@ -2,6 +2,9 @@ package config
 
 import (
 	"fmt"
+	"strings"
+
+	"crypto/sha256"
 
 	"github.com/BurntSushi/toml"
 )
@@ -11,37 +14,250 @@ import (
 // Config holds all information parsed from
 // supplied config file.
 type Config struct {
-	Server   Server
-	Settings Settings
-	Session  Session
+	Server      Server
+	Settings    Settings
+	Session     Session
+	Metrics     Metrics
+	Output      Output
+	UserDB      UserDB
+	Seed        Seed
+	Verify      Verify
+	Convergence Convergence
+	Connection  Connection
+	Idle        Idle
+	Soak        Soak
+	Auth        Auth
+	Hierarchy   Hierarchy
 }
 
 // Server holds all server information
-// including hostname and port.
+// including hostname and port. Endpoints
+// lists further addresses of the service,
+// Policy decides how sessions are spread
+// over endpoints with role "write" and is
+// one of "roundrobin" (default), "sticky"
+// or "random".
 type Server struct {
-	Addr string
-	TLS  bool
+	Addr      string
+	TLS       bool
+	Policy    string
+	Endpoints []Endpoint
+}
+
+// Endpoint is an address of the service under test
+// with a Role. If endpoints with role "write" are
+// configured, sessions are sent to them instead of
+// Addr, in proportion to their Weight. Replicas with
+// role "probe" are polled for the changes that sessions
+// write in order to measure the replication lag.
+type Endpoint struct {
+	Addr   string
+	Role   string
+	Weight int
 }
 
 // Settings holds all global parameters such
 // as the number of threads and the seed to
 // generate the involved IMAP commands.
+// UserSelection is one of "uniform" (default),
+// "roundrobin" or "zipf" with exponent ZipfS.
+// If ExclusiveUsers is set, no user has two
+// concurrent sessions.
 type Settings struct {
-	Threads  int
-	Sessions int
-	Seed     int64
-	Throttle int
+	Threads        int
+	Sessions       int
+	Seed           int64
+	Throttle       int
+	UserSelection  string
+	ZipfS          float64
+	ExclusiveUsers bool
 }
 
 // Session holds all information about the
-// length of one session.
+// length of one session. Devices is the number
+// of concurrent connections of one user that
+// together operate on shared folders. If Discover
+// is set, sessions start out from the folders and
+// messages found in the mailbox after logging in.
+// Up to Pipeline independent commands are sent
+// before reading their responses, values below
+// two disable pipelining. Enable lists extensions
+// such as CONDSTORE or QRESYNC that are turned
+// on via ENABLE if the server supports them.
+// Literals is "auto" (default) to send messages
+// as non-synchronizing literals if the server
+// advertises LITERAL+ or LITERAL-, or "sync" to
+// always wait for the server's continuation.
+// Transfer is the probability of filing a message
+// into another folder via COPY or MOVE, 0 (default)
+// never does.
 type Session struct {
 	MinLength int
 	MaxLength int
+	Devices   int
+	Discover  bool
+	Pipeline  int
+	Enable    []string
+	Literals  string
+	Transfer  float64
+}
+
+// Metrics holds the address the Prometheus
+// metrics endpoint listens on. An empty address
+// disables the endpoint.
+type Metrics struct {
+	Addr string
+}
+
+// Output holds settings that apply to every output
+// of a run, i.e. the results log, its upload, and the
+// log lines of the tool. Passwords is the redaction
+// policy for user passwords and one of "omit" (the
+// default), "hash" or "keep".
+type Output struct {
+	Passwords string
+}
+
+// UserDB describes how the userdb file is read. Format
+// is "passwd" (the default) for Dovecot passwd-file lines
+// or "csv" for 'user,password' records. If PasswordEnv
+// is set, all users share the password read from that
+// environment variable. Otherwise, if PasswordTemplate is
+// set, each user's password is derived from it by replacing
+// %u (user name), %n (user part) and %d (domain part).
+type UserDB struct {
+	Format           string
+	PasswordEnv      string
+	PasswordTemplate string
+}
+
+// Seed describes the mailbox the seed subcommand fills
+// each user's account with. Folders lists the folders to
+// create in addition to INBOX, using "/" as hierarchy
+// delimiter. Every folder including INBOX receives between
+// MinMessages and MaxMessages messages of MinLines to
+// MaxLines lines each, of which a share of SeenRatio is
+// flagged \Seen. If MultiAppend is set, up to BatchSize
+// messages are uploaded per APPEND (RFC 3502).
+type Seed struct {
+	Folders     []string
+	MinMessages int
+	MaxMessages int
+	MinLines    int
+	MaxLines    int
+	SeenRatio   float64
+	MultiAppend bool
+	BatchSize   int
+}
+
+// Verify configures the comparison of the mailbox state
+// expected from the generated sessions with the state on
+// the server. If Sessions is set, each session's mailbox
+// is verified right after it finished. If Run is set, all
+// mailboxes are verified once more at the end of the run,
+// which requires ExclusiveUsers in the [settings] section.
+type Verify struct {
+	Sessions bool
+	Run      bool
+}
+
+// Convergence configures the measurement of the replication
+// lag. After every write, a probe replica is polled every
+// Interval milliseconds until the change becomes visible or
+// Timeout milliseconds have passed.
+type Convergence struct {
+	Interval int
+	Timeout  int
+}
+
+// Connection decides how long connections live. In Mode
+// "session" (the default), each session connects, logs in
+// and logs out. In Mode "reuse", a worker keeps up to
+// PoolSize authenticated connections of finished sessions
+// and runs later sessions of the same user over them.
+// Mode "persistent" additionally sends NOOP on waiting
+// connections every KeepAlive seconds, like clients that
+// stay logged in. Connections are logged out after Lifetime
+// seconds, zero keeps them until the end of the run.
+type Connection struct {
+	Mode      string
+	PoolSize  int
+	KeepAlive int
+	Lifetime  int
+}
+
+// Idle configures a population of Connections that wait
+// in IDLE (RFC 2177) while sessions run, spread over the
+// users and over Folders (default INBOX, using "/" as
+// hierarchy delimiter). IDLE is re-issued every Renew
+// seconds, as clients do to avoid being logged out.
+type Idle struct {
+	Connections int
+	Folders     []string
+	Renew       int
+}
+
+// Soak describes the connection scaling run of the soak
+// subcommand. It opens Connections authenticated connections,
+// Rate per second, holds all of them for Hold seconds once
+// the last one was opened and keeps them alive by sending
+// NOOP every KeepAlive seconds, or by waiting in IDLE on
+// INBOX and renewing it every KeepAlive seconds if Idle
+// is set. The summary divides the connection count into
+// Steps equal ranges and reports the latencies observed
+// while the count was within each of them.
+type Soak struct {
+	Connections int
+	Rate        float64
+	Hold        int
+	KeepAlive   int
+	Idle        bool
+	Steps       int
+}
+
+// Auth decides how users log in. An empty Mechanism sends
+// the LOGIN command, otherwise the named SASL mechanism
+// ("PLAIN", "LOGIN", "CRAM-MD5" or "XOAUTH2") is used via
+// AUTHENTICATE. Users may choose their own mechanism in
+// the userdb. If InitialResponse is set, mechanisms that
+// start with a client response send it along with the
+// command (SASL-IR, RFC 4959).
+type Auth struct {
+	Mechanism       string
+	InitialResponse bool
+}
+
+// Hierarchy shapes the folder trees sessions build.
+// New folders are created below an existing folder
+// with probability Nest, as long as the tree stays
+// within Depth levels and every folder has at most
+// Breadth children. A Depth of 1 keeps all folders
+// at the top level. TreeOps is the probability of
+// a RENAME, SUBSCRIBE or UNSUBSCRIBE instead of any
+// other command, 0 (default) never generates them.
+type Hierarchy struct {
+	Depth   int
+	Breadth int
+	Nest    float64
+	TreeOps float64
 }
 
 // Functions
 
+// CheckMechanism returns the canonical name of the
+// SASL mechanism, or an error if it is not supported.
+func CheckMechanism(mechanism string) (string, error) {
+
+	canonical := strings.ToUpper(mechanism)
+
+	switch canonical {
+	case "", "PLAIN", "LOGIN", "CRAM-MD5", "XOAUTH2":
+		return canonical, nil
+	}
+
+	return "", fmt.Errorf("unknown SASL mechanism '%s', choose PLAIN, LOGIN, CRAM-MD5 or XOAUTH2", mechanism)
+}
+
 // LoadConfig decodes the config file and creates a
 // Config object.
 func LoadConfig(configFile string) (*Config, error) {
@@ -49,10 +265,288 @@ func LoadConfig(configFile string) (*Config, error) {
 	conf := &Config{}
 
 	// Parse values from TOML file into struct.
-	_, err := toml.DecodeFile(configFile, conf)
+	meta, err := toml.DecodeFile(configFile, conf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read in TOML config file at '%s' with: %v", configFile, err)
 	}
 
+	// Users have one device unless configured otherwise.
+	if conf.Session.Devices == 0 {
+		conf.Session.Devices = 1
+	}
+
+	// All devices of a user have to run concurrently.
+	if conf.Session.Devices > conf.Settings.Threads {
+		return nil, fmt.Errorf("number of devices per user (%d) exceeds number of threads (%d)", conf.Session.Devices, conf.Settings.Threads)
+	}
+
+	// The expected states of overlapping sessions
+	// of a user cannot be merged for the run.
+	if conf.Verify.Run && !conf.Settings.ExclusiveUsers {
+		return nil, fmt.Errorf("verifying the run requires exclusiveusers = true in the [settings] section")
+	}
+
+	if conf.Hierarchy.Depth == 0 {
+		conf.Hierarchy.Depth = 1
+	}
+
+	if conf.Hierarchy.Breadth == 0 {
+		conf.Hierarchy.Breadth = 5
+	}
+
+	// A nest of 0 is valid and keeps new
+	// folders at the top level.
+	if !meta.IsDefined("hierarchy", "nest") {
+		conf.Hierarchy.Nest = 0.5
+	}
+
+	if (conf.Hierarchy.Depth < 0) || (conf.Hierarchy.Breadth < 0) || (conf.Hierarchy.Nest < 0) || (conf.Hierarchy.Nest > 1) {
+		return nil, fmt.Errorf("hierarchy depth and breadth must not be negative and nest must be between 0 and 1")
+	}
+
+	if (conf.Hierarchy.TreeOps < 0) || (conf.Hierarchy.TreeOps > 1) {
+		return nil, fmt.Errorf("hierarchy treeops must be between 0 and 1")
+	}
+
+	if (conf.Session.Transfer < 0) || (conf.Session.Transfer > 1) {
+		return nil, fmt.Errorf("session transfer must be between 0 and 1")
+	}
+
+	for i, endpoint := range conf.Server.Endpoints {
+
+		if (endpoint.Role != "write") && (endpoint.Role != "probe") {
+			return nil, fmt.Errorf("unknown role '%s' of endpoint %s, choose write or probe", endpoint.Role, endpoint.Addr)
+		}
+
+		// Endpoints have equal weights
+		// unless configured otherwise.
+		if endpoint.Weight == 0 {
+			conf.Server.Endpoints[i].Weight = 1
+		}
+
+		if endpoint.Weight < 0 {
+			return nil, fmt.Errorf("weight of endpoint %s must not be negative", endpoint.Addr)
+		}
+	}
+
+	// Commands outside of sessions, e.g. for seeding,
+	// go to the first write endpoint if Addr is unset.
+	if conf.Server.Addr == "" {
+
+		targets := conf.Server.Targets()
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no server address configured")
+		}
+
+		conf.Server.Addr = targets[0].Addr
+	}
+
+	if conf.Convergence.Interval == 0 {
+		conf.Convergence.Interval = 10
+	}
+
+	if conf.Convergence.Timeout == 0 {
+		conf.Convergence.Timeout = 10000
+	}
+
+	if (conf.Convergence.Interval < 0) || (conf.Convergence.Timeout < 0) {
+		return nil, fmt.Errorf("convergence interval and timeout must not be negative")
+	}
+
+	// Seeded messages have the size of those
+	// in sessions unless configured otherwise.
+	if !meta.IsDefined("seed", "minlines") {
+		conf.Seed.MinLines = 10
+	}
+
+	if !meta.IsDefined("seed", "maxlines") {
+		conf.Seed.MaxLines = 512
+	}
+
+	if conf.Seed.MinMessages < 0 {
+		return nil, fmt.Errorf("minimum number of seeded messages must not be negative")
+	}
+
+	if conf.Seed.MinMessages > conf.Seed.MaxMessages {
+		return nil, fmt.Errorf("minimum number of seeded messages (%d) exceeds maximum (%d)", conf.Seed.MinMessages, conf.Seed.MaxMessages)
+	}
+
+	if (conf.Seed.MinLines < 1) || (conf.Seed.MinLines > conf.Seed.MaxLines) {
+		return nil, fmt.Errorf("invalid range of lines of seeded messages (%d to %d)", conf.Seed.MinLines, conf.Seed.MaxLines)
+	}
+
+	if conf.Seed.BatchSize == 0 {
+		conf.Seed.BatchSize = 10
+	}
+
+	// Connections last for one session
+	// unless configured otherwise.
+	if conf.Connection.Mode == "" {
+		conf.Connection.Mode = "session"
+	}
+
+	if (conf.Connection.Mode != "session") && (conf.Connection.Mode != "reuse") && (conf.Connection.Mode != "persistent") {
+		return nil, fmt.Errorf("unknown connection mode '%s', choose session, reuse or persistent", conf.Connection.Mode)
+	}
+
+	if conf.Connection.PoolSize == 0 {
+		conf.Connection.PoolSize = 10
+	}
+
+	if conf.Connection.Mode == "persistent" {
+
+		if conf.Connection.KeepAlive == 0 {
+			conf.Connection.KeepAlive = 300
+		}
+
+		if conf.Connection.Lifetime == 0 {
+			conf.Connection.Lifetime = 3600
+		}
+	}
+
+	if (conf.Connection.PoolSize < 0) || (conf.Connection.KeepAlive < 0) || (conf.Connection.Lifetime < 0) {
+		return nil, fmt.Errorf("connection pool size, keepalive and lifetime must not be negative")
+	}
+
+	// Idle connections wait for new messages in INBOX
+	// and renew IDLE after 29 minutes as recommended.
+	if len(conf.Idle.Folders) == 0 {
+		conf.Idle.Folders = []string{"INBOX"}
+	}
+
+	if conf.Idle.Renew == 0 {
+		conf.Idle.Renew = 1740
+	}
+
+	if (conf.Idle.Connections < 0) || (conf.Idle.Renew < 0) {
+		return nil, fmt.Errorf("number of idle connections and renew interval must not be negative")
+	}
+
+	// Without discovery, sessions only work on folders
+	// of their own that no idle connection waits on.
+	if (conf.Idle.Connections > 0) && !conf.Session.Discover {
+		return nil, fmt.Errorf("idle connections require discover = true in the [session] section")
+	}
+
+	if conf.Soak.Rate == 0 {
+		conf.Soak.Rate = 100
+	}
+
+	if conf.Soak.Hold == 0 {
+		conf.Soak.Hold = 60
+	}
+
+	if conf.Soak.KeepAlive == 0 {
+		conf.Soak.KeepAlive = 60
+	}
+
+	if conf.Soak.Steps == 0 {
+		conf.Soak.Steps = 10
+	}
+
+	if (conf.Soak.Connections < 0) || (conf.Soak.Rate < 0) || (conf.Soak.Hold < 0) || (conf.Soak.KeepAlive < 0) || (conf.Soak.Steps < 0) {
+		return nil, fmt.Errorf("soak connections, rate, hold, keepalive and steps must not be negative")
+	}
+
+	conf.Auth.Mechanism, err = CheckMechanism(conf.Auth.Mechanism)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range conf.Session.Enable {
+		conf.Session.Enable[i] = strings.ToUpper(conf.Session.Enable[i])
+	}
+
+	if conf.Session.Literals == "" {
+		conf.Session.Literals = "auto"
+	}
+
+	if (conf.Session.Literals != "auto") && (conf.Session.Literals != "sync") {
+		return nil, fmt.Errorf("unknown literal mode '%s', choose auto or sync", conf.Session.Literals)
+	}
+
+	// Passwords are omitted unless configured otherwise.
+	if conf.Output.Passwords == "" {
+		conf.Output.Passwords = "omit"
+	}
+
+	if (conf.Output.Passwords != "omit") && (conf.Output.Passwords != "hash") && (conf.Output.Passwords != "keep") {
+		return nil, fmt.Errorf("unknown password redaction policy '%s', choose omit, hash or keep", conf.Output.Passwords)
+	}
+
 	return conf, nil
 }
+
+// Targets returns the endpoints sessions are sent to,
+// i.e. all endpoints with role "write" or, if there
+// are none, Addr.
+func (s Server) Targets() []Endpoint {
+
+	var targets []Endpoint
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Role == "write" {
+			targets = append(targets, endpoint)
+		}
+	}
+
+	if (len(targets) == 0) && (s.Addr != "") {
+
+		targets = append(targets, Endpoint{
+			Addr:   s.Addr,
+			Role:   "write",
+			Weight: 1,
+		})
+	}
+
+	return targets
+}
+
+// Probes returns the addresses of all
+// endpoints with role "probe".
+func (s Server) Probes() []string {
+
+	var probes []string
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Role == "probe" {
+			probes = append(probes, endpoint.Addr)
+		}
+	}
+
+	return probes
+}
+
+// Redacted returns a copy of the configuration to be written
+// to the results header, in which the password template is
+// redacted according to the password policy, as it reveals
+// the passwords of all users.
+func (c *Config) Redacted() *Config {
+
+	redacted := *c
+
+	if redacted.UserDB.PasswordTemplate != "" {
+		redacted.UserDB.PasswordTemplate = c.Output.Redact(c.UserDB.PasswordTemplate)
+	}
+
+	return &redacted
+}
+
+// Omit reports whether passwords should
+// be left out of outputs entirely.
+func (o Output) Omit() bool {
+	return o.Passwords == "omit"
+}
+
+// Redact applies the redaction policy to a password. Hashed
+// passwords are represented by their hex encoded SHA-256
+// sum, omitted passwords by a placeholder.
+func (o Output) Redact(password string) string {
+
+	switch o.Passwords {
+	case "keep":
+		return password
+	case "hash":
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(password)))
+	}
+
+	return "<omitted>"
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Functions
+
+// loadConfig writes the minimal config followed by
+// extra to a temporary file and loads it.
+func loadConfig(t *testing.T, extra string) (*Config, error) {
+
+	content := "[settings]\nthreads = 2\nsessions = 1\n\n[server]\naddr = \"127.0.0.1:993\"\n\n" + extra
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadConfig(path)
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		extra    string
+		nest     float64
+		minLines int
+		maxLines int
+	}{
+		{"unset", "", 0.5, 10, 512},
+		{"nest 0", "[hierarchy]\nnest = 0\n", 0, 10, 512},
+		{"nest 1", "[hierarchy]\nnest = 1.0\n", 1, 10, 512},
+		{"min lines only", "[seed]\nminlines = 100\n", 0.5, 100, 512},
+		{"max lines only", "[seed]\nmaxlines = 20\n", 0.5, 10, 20},
+		{"both lines", "[seed]\nminlines = 2\nmaxlines = 4\n", 0.5, 2, 4},
+	}
+
+	for _, test := range tests {
+
+		conf, err := loadConfig(t, test.extra)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if conf.Hierarchy.Nest != test.nest {
+			t.Errorf("%s: nest = %v, want %v", test.name, conf.Hierarchy.Nest, test.nest)
+		}
+
+		if (conf.Seed.MinLines != test.minLines) || (conf.Seed.MaxLines != test.maxLines) {
+			t.Errorf("%s: lines = %d to %d, want %d to %d", test.name, conf.Seed.MinLines, conf.Seed.MaxLines, test.minLines, test.maxLines)
+		}
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		extra string
+	}{
+		{"negative nest", "[hierarchy]\nnest = -0.1\n"},
+		{"nest above 1", "[hierarchy]\nnest = 1.5\n"},
+		{"min lines above max lines", "[seed]\nminlines = 600\n"},
+		{"zero max lines", "[seed]\nmaxlines = 0\n"},
+		{"negative min messages", "[seed]\nminmessages = -1\nmaxmessages = 5\n"},
+		{"negative interval", "[convergence]\ninterval = -10\n"},
+		{"negative timeout", "[convergence]\ntimeout = -1\n"},
+		{"idle without discover", "[idle]\nconnections = 5\n"},
+	}
+
+	for _, test := range tests {
+
+		_, err := loadConfig(t, test.extra)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -2,49 +2,312 @@ package config
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/csv"
 	"io/ioutil"
 )
 
+// Variables
+
+// plainSchemes lists the Dovecot password schemes
+// that store the password in plain text.
+var plainSchemes = map[string]bool{
+	"PLAIN":     true,
+	"CLEARTEXT": true,
+}
+
 // Structs
 
 // User represents a user by name and password.
+// Mechanism is the SASL mechanism the user logs
+// in with, empty for the configured default.
 type User struct {
-	Username string
-	Password string
+	Username  string
+	Password  string
+	Mechanism string
 }
 
 // Functions
 
-// LoadUsers populates all users from supplied
-// file into a slice of above User struct.
-func LoadUsers(userdbFile string) ([]User, error) {
+// ExpandTemplate replaces the Dovecot style variables
+// %u (full user name), %n (user part), %d (domain part)
+// and %% in template for the supplied user name.
+func ExpandTemplate(template string, username string) string {
+
+	user, domain := username, ""
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		user, domain = username[:i], username[(i+1):]
+	}
+
+	replacer := strings.NewReplacer("%%", "%", "%u", username, "%n", user, "%d", domain)
+
+	return replacer.Replace(template)
+}
+
+// parsePasswdLine parses one line in Dovecot passwd-file
+// format 'user:{SCHEME}password:uid:gid:gecos:home:shell:extra'
+// of which all fields after the password are optional. Of the
+// space separated extra fields, 'mechanism=NAME' selects the
+// SASL mechanism of the user, all other fields are ignored.
+// needPassword indicates whether the password has to be
+// usable for logging in, i.e. stored in plain text.
+func parsePasswdLine(line string, needPassword bool) (User, error) {
+
+	fields := strings.Split(line, ":")
+	if len(fields) < 2 {
+		return User{}, fmt.Errorf("expected 'user:password' but found no ':'")
+	}
+
+	if fields[0] == "" {
+		return User{}, fmt.Errorf("empty user name")
+	}
+
+	user := User{
+		Username: fields[0],
+	}
+
+	password := fields[1]
+
+	// Split off an optional {SCHEME} prefix.
+	if strings.HasPrefix(password, "{") {
+
+		end := strings.Index(password, "}")
+		if end < 0 {
+			return User{}, fmt.Errorf("unterminated password scheme in '%s'", password)
+		}
+
+		scheme := strings.ToUpper(password[1:end])
+		password = password[(end + 1):]
+
+		if needPassword && !plainSchemes[scheme] {
+			return User{}, fmt.Errorf("password of user '%s' uses scheme {%s} that cannot be used to log in, store it as {PLAIN} or configure a password source", user.Username, scheme)
+		}
+	}
+
+	user.Password = password
+
+	if len(fields) > 7 {
+
+		for _, extra := range strings.Fields(strings.Join(fields[7:], ":")) {
+
+			if !strings.HasPrefix(extra, "mechanism=") {
+				continue
+			}
+
+			mechanism, err := CheckMechanism(strings.TrimPrefix(extra, "mechanism="))
+			if err != nil {
+				return User{}, err
+			}
+
+			user.Mechanism = mechanism
+		}
+	}
+
+	return user, nil
+}
+
+// loadPasswdUsers reads users from content in Dovecot
+// passwd-file format. Blank lines and lines starting
+// with '#' are skipped.
+func loadPasswdUsers(userdbFile string, content []byte, needPassword bool) ([]User, error) {
+
+	users := make([]User, 0, 30)
+
+	// Split content at newline.
+	lines := bytes.Split(content, []byte("\n"))
+
+	for i, line := range lines {
+
+		// Passwords may begin or end with spaces, so
+		// only the line ending is removed before parsing.
+		entry := strings.TrimSuffix(string(line), "\r")
+
+		trimmed := strings.TrimSpace(entry)
+		if (trimmed == "") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		user, err := parsePasswdLine(entry, needPassword)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", userdbFile, (i + 1), err)
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// loadCSVUsers reads users from content with one
+// 'user,password' record per line, optionally followed
+// by the SASL mechanism of the user. An optional header
+// line naming the columns and lines starting with '#'
+// are skipped.
+func loadCSVUsers(userdbFile string, content []byte, needPassword bool) ([]User, error) {
 
 	users := make([]User, 0, 30)
 
+	r := csv.NewReader(bytes.NewReader(content))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", userdbFile, err)
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if first && (len(record) > 0) {
+
+			header := strings.ToLower(record[0])
+			if (header == "user") || (header == "username") {
+				continue
+			}
+		}
+
+		if record[0] == "" {
+			return nil, fmt.Errorf("%s:%d: empty user name", userdbFile, line)
+		}
+
+		if needPassword && (len(record) < 2) {
+			return nil, fmt.Errorf("%s:%d: expected 'user,password' but found %d field(s)", userdbFile, line, len(record))
+		}
+
+		user := User{
+			Username: record[0],
+		}
+
+		if len(record) > 1 {
+			user.Password = record[1]
+		}
+
+		if len(record) > 2 {
+
+			user.Mechanism, err = CheckMechanism(record[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", userdbFile, line, err)
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// LoadUsers populates all users from supplied
+// file into a slice of above User struct. The file
+// is parsed according to the format set in conf,
+// passwords are taken from the configured source.
+func LoadUsers(userdbFile string, conf UserDB) ([]User, error) {
+
 	// Load whole file content.
 	content, err := ioutil.ReadFile(userdbFile)
 	if err != nil {
 		return nil, err
 	}
 
-	// Split content at newline.
-	lines := bytes.Split(content, []byte("\n"))
+	// Passwords in the file only matter if they
+	// are not supplied by environment or template.
+	needPassword := (conf.PasswordEnv == "") && (conf.PasswordTemplate == "")
 
-	for _, line := range lines {
+	var users []User
 
-		if len(line) > 0 {
+	switch conf.Format {
+	case "", "passwd":
+		users, err = loadPasswdUsers(userdbFile, content, needPassword)
+	case "csv":
+		users, err = loadCSVUsers(userdbFile, content, needPassword)
+	default:
+		err = fmt.Errorf("unknown userdb format '%s', choose passwd or csv", conf.Format)
+	}
 
-			// Split line at ':{plain}'.
-			userData := bytes.Split(line, []byte(":{plain}"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s: no users found", userdbFile)
+	}
+
+	if conf.PasswordEnv != "" {
+
+		password := os.Getenv(conf.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("environment variable %s holding the users' password is empty", conf.PasswordEnv)
+		}
+
+		for i := range users {
+			users[i].Password = password
+		}
+	} else if conf.PasswordTemplate != "" {
 
-			// Append new User element with parsed data.
-			users = append(users, User{
-				Username: string(userData[0]),
-				Password: string(userData[1]),
-			})
+		for i := range users {
+			users[i].Password = ExpandTemplate(conf.PasswordTemplate, users[i].Username)
 		}
 	}
 
 	return users, nil
 }
+
+// PasswdLine formats user as a line of a Dovecot passwd-file
+// with the password stored in the supplied scheme. Scheme
+// "plain" results in the same '{plain}' format the userdb
+// of this tool and pluto use, "ssha512" stores a salted
+// SHA-512 hash that Dovecot verifies as {SSHA512}.
+func PasswdLine(user User, scheme string) (string, error) {
+
+	switch scheme {
+	case "plain":
+		return fmt.Sprintf("%s:{plain}%s", user.Username, user.Password), nil
+	case "ssha512":
+
+		salt := make([]byte, 8)
+
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+
+		sum := sha512.Sum512(append([]byte(user.Password), salt...))
+		hash := base64.StdEncoding.EncodeToString(append(sum[:], salt...))
+
+		return fmt.Sprintf("%s:{SSHA512}%s", user.Username, hash), nil
+	}
+
+	return "", fmt.Errorf("unknown password scheme '%s', choose plain or ssha512", scheme)
+}
+
+// WriteUsers writes users as Dovecot passwd-file
+// with passwords stored in the supplied scheme to w.
+func WriteUsers(w io.Writer, users []User, scheme string) error {
+
+	for _, user := range users {
+
+		line, err := PasswdLine(user, scheme)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"testing"
+)
+
+// Functions
+
+func TestParsePasswdLine(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		line         string
+		needPassword bool
+		want         User
+		fail         bool
+	}{
+		{
+			name:         "without scheme",
+			line:         "user1:secret",
+			needPassword: true,
+			want:         User{Username: "user1", Password: "secret"},
+		},
+		{
+			name:         "plain scheme",
+			line:         "user1:{plain}secret::::::",
+			needPassword: true,
+			want:         User{Username: "user1", Password: "secret"},
+		},
+		{
+			name:         "cleartext scheme",
+			line:         "user1:{CLEARTEXT}secret",
+			needPassword: true,
+			want:         User{Username: "user1", Password: "secret"},
+		},
+		{
+			name:         "non-plain scheme",
+			line:         "user1:{SSHA512}aGFzaA==",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "non-plain scheme without password",
+			line:         "user1:{SSHA512}aGFzaA==",
+			needPassword: false,
+			want:         User{Username: "user1", Password: "aGFzaA=="},
+		},
+		{
+			name:         "unterminated scheme",
+			line:         "user1:{plain secret",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "mechanism",
+			line:         "user1:{plain}secret:1000:1000::/home/user1::userdb_quota=1G mechanism=cram-md5",
+			needPassword: true,
+			want:         User{Username: "user1", Password: "secret", Mechanism: "CRAM-MD5"},
+		},
+		{
+			name:         "unknown mechanism",
+			line:         "user1:{plain}secret:::::: mechanism=GSSAPI",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "spaces in password",
+			line:         "user1:{plain}  secret ",
+			needPassword: true,
+			want:         User{Username: "user1", Password: "  secret "},
+		},
+		{
+			name:         "empty user name",
+			line:         ":secret",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "missing password",
+			line:         "user1",
+			needPassword: true,
+			fail:         true,
+		},
+	}
+
+	for _, test := range tests {
+
+		got, err := parsePasswdLine(test.line, test.needPassword)
+
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error for '%s' but got %+v", test.name, test.line, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: parsePasswdLine('%s') = %+v, want %+v", test.name, test.line, got, test.want)
+		}
+	}
+}
+
+func TestLoadPasswdUsers(t *testing.T) {
+
+	content := "# comment\r\nuser1:{plain} secret \r\n\n   \nuser2:{plain}other\n"
+
+	users, err := loadPasswdUsers("userdb", []byte(content), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []User{
+		{Username: "user1", Password: " secret "},
+		{Username: "user2", Password: "other"},
+	}
+
+	if len(users) != len(want) {
+		t.Fatalf("got %d users, want %d", len(users), len(want))
+	}
+
+	for i := range want {
+		if users[i] != want[i] {
+			t.Errorf("user %d = %+v, want %+v", i, users[i], want[i])
+		}
+	}
+}
+
+func TestLoadCSVUsers(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		content      string
+		needPassword bool
+		want         []User
+		fail         bool
+	}{
+		{
+			name:         "plain records",
+			content:      "user1,secret\nuser2,other\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: "secret"}, {Username: "user2", Password: "other"}},
+		},
+		{
+			name:         "header",
+			content:      "username,password,mechanism\nuser1,secret,plain\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: "secret", Mechanism: "PLAIN"}},
+		},
+		{
+			name:         "header only in first line",
+			content:      "user1,secret\nuser,other\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: "secret"}, {Username: "user", Password: "other"}},
+		},
+		{
+			name:         "comments",
+			content:      "# user,password\nuser1,secret\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: "secret"}},
+		},
+		{
+			name:         "quoted password with spaces",
+			content:      "user1,\" secret \"\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: " secret "}},
+		},
+		{
+			name:         "mechanism",
+			content:      "user1,secret,xoauth2\n",
+			needPassword: true,
+			want:         []User{{Username: "user1", Password: "secret", Mechanism: "XOAUTH2"}},
+		},
+		{
+			name:         "unknown mechanism",
+			content:      "user1,secret,GSSAPI\n",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "missing password",
+			content:      "user1\n",
+			needPassword: true,
+			fail:         true,
+		},
+		{
+			name:         "missing password from template",
+			content:      "user1\n",
+			needPassword: false,
+			want:         []User{{Username: "user1"}},
+		},
+		{
+			name:         "empty user name",
+			content:      ",secret\n",
+			needPassword: true,
+			fail:         true,
+		},
+	}
+
+	for _, test := range tests {
+
+		got, err := loadCSVUsers("userdb.csv", []byte(test.content), test.needPassword)
+
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error but got %+v", test.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+
+		for i := range test.want {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: user %d = %+v, want %+v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+
+	tests := []struct {
+		template string
+		username string
+		want     string
+	}{
+		{"%u", "user1@example.com", "user1@example.com"},
+		{"%n", "user1@example.com", "user1"},
+		{"%d", "user1@example.com", "example.com"},
+		{"pw-%n-%d", "user1@example.com", "pw-user1-example.com"},
+		{"%n@%d", "first@last@example.com", "first@last@example.com"},
+		{"%n|%d|", "user1", "user1||"},
+		{"100%%-%n", "user1@example.com", "100%-user1"},
+		{"%%u", "user1@example.com", "%u"},
+		{"fixed", "user1@example.com", "fixed"},
+	}
+
+	for _, test := range tests {
+
+		got := ExpandTemplate(test.template, test.username)
+		if got != test.want {
+			t.Errorf("ExpandTemplate('%s', '%s') = '%s', want '%s'", test.template, test.username, got, test.want)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
+	"crypto/tls"
 	"encoding/json"
 	"math/rand"
 	"net/http"
@@ -13,24 +16,75 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/go-pluto/benchmark/resultlog"
 	"github.com/go-pluto/benchmark/worker"
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
 )
 
+// Variables
+
+// version and commit identify the build of this tool.
+// They are set at build time via -ldflags.
+var (
+	version string
+	commit  string
+)
+
 // Functions
 
-func main() {
+// pickSeed returns the configured seed or, if none
+// has been configured, one based on the current time.
+func pickSeed(conf *config.Config) int64 {
 
-	go func() {
-		glog.Warning(http.ListenAndServe("127.0.0.1:6060", nil))
-	}()
+	if conf.Settings.Seed != 0 {
+		return conf.Settings.Seed
+	}
+
+	return time.Now().UnixNano()
+}
+
+func main() {
 
 	// Parse the input flags.
 	configFlag := flag.String("config", "test-config.toml", "Specify location of config file that describes test setup configuration.")
 	userdbFlag := flag.String("userdb", "userdb.passwd", "Specify location of the user/password file.")
 	flag.Parse()
 
+	// Run the benchmark unless a subcommand
+	// has been supplied after the flags.
+	switch flag.Arg(0) {
+	case "":
+		runBenchmark(*configFlag, *userdbFlag)
+	case "compare":
+		runCompare(flag.Args()[1:])
+	case "analyze":
+		runAnalyze(flag.Args()[1:])
+	case "report":
+		runReport(flag.Args()[1:])
+	case "users":
+		runUsers(flag.Args()[1:])
+	case "seed":
+		runSeed(*configFlag, *userdbFlag, flag.Args()[1:])
+	case "reset":
+		runReset(*configFlag, *userdbFlag, flag.Args()[1:])
+	case "soak":
+		runSoak(*configFlag, *userdbFlag, flag.Args()[1:])
+	default:
+		glog.Fatalf("Unknown subcommand '%s'", flag.Arg(0))
+	}
+}
+
+// runBenchmark generates IMAP traffic as described by the
+// config file, collects all response times in a log file
+// and uploads it to GCS.
+func runBenchmark(configFile string, userdbFile string) {
+
+	go func() {
+		glog.Warning(http.ListenAndServe("127.0.0.1:6060", nil))
+	}()
+
 	// Check that associated Google Cloud Project
 	// is set as environment variable.
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
@@ -45,24 +99,66 @@ func main() {
 	}
 
 	// Read configuration from file.
-	conf, err := config.LoadConfig(*configFlag)
+	conf, err := config.LoadConfig(configFile)
 	if err != nil {
 		glog.Fatalf("Error loading config: %v", err)
 	}
 
+	// Expose Prometheus metrics if configured.
+	if conf.Metrics.Addr != "" {
+
+		go func() {
+			glog.Warning(metrics.Serve(conf.Metrics.Addr))
+		}()
+	}
+
 	// Encode the configuration in json
-	jsonConf, err := json.Marshal(conf)
+	jsonConf, err := json.Marshal(conf.Redacted())
 	if err != nil {
 		glog.Fatalf("Error encoding config in JSON: %v", err)
 	}
 
 	// Load users from userdb file.
-	users, err := config.LoadUsers(*userdbFlag)
+	users, err := config.LoadUsers(userdbFile, conf.UserDB)
+	if err != nil {
+		glog.Fatalf("Error loading users from '%s' file: %v", userdbFile, err)
+	}
+
+	// Use the configured seed or pick one
+	// if none has been configured.
+	seed := pickSeed(conf)
+
+	// Collect information about this run.
+	metadata, err := resultlog.NewMetadata(version, commit, userdbFile, seed)
 	if err != nil {
-		glog.Fatalf("Error loading users from '%s' file: %v", *userdbFlag, err)
+		glog.Fatalf("Error collecting run metadata: %v", err)
 	}
 
-	timestamp := time.Now()
+	// Connect once to the server to record its
+	// address and the negotiated TLS parameters.
+	remoteAddr, tlsState, err := worker.Probe(conf.Server.Addr)
+	if err != nil {
+		glog.Fatalf("Unable to connect to remote server %s: %v", conf.Server.Addr, err)
+	}
+
+	metadata.ServerAddr = conf.Server.Addr
+	metadata.ResolvedAddr = remoteAddr.String()
+	metadata.TLSVersion = tls.VersionName(tlsState.Version)
+	metadata.TLSCipher = tls.CipherSuiteName(tlsState.CipherSuite)
+
+	// Record what the server advertises after login.
+	metadata.Capabilities, metadata.Delimiter, err = worker.ProbeLogin(conf, users[0])
+	if err != nil {
+		glog.Warningf("Unable to query server capabilities: %v", err)
+	}
+
+	// Encode the metadata in json.
+	jsonMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		glog.Fatalf("Error encoding metadata in JSON: %v", err)
+	}
+
+	timestamp := metadata.Start
 
 	// Check results folder existence and create
 	// a log file for this run.
@@ -74,7 +170,7 @@ func main() {
 	defer logFile.Sync()
 
 	// Seed the random number generator.
-	rand.Seed(conf.Settings.Seed)
+	rand.Seed(seed)
 
 	// Write first line with host information to GCS.
 	// TODO comment
@@ -89,6 +185,17 @@ func main() {
 		glog.Fatal(err)
 	}
 
+	// Add the metadata of this run to the header.
+	_, err = logFile.WriteString(",\"Metadata\":")
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	_, err = logFile.Write(jsonMetadata)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
 	// TODO comment
 	_, err = logFile.WriteString(",\"Sessions\":[")
 	if err != nil {
@@ -100,12 +207,36 @@ func main() {
 	jobs := make(chan worker.Session, 100)
 	logger := make(chan []string, 100)
 
+	// Decide on users according to the configured strategy.
+	selector, err := worker.NewUserSelector(conf, users)
+	if err != nil {
+		glog.Fatalf("Error setting up user selection: %v", err)
+	}
+
+	// Spread sessions over the write endpoints
+	// according to the configured policy.
+	endpoints, err := worker.NewEndpointSelector(conf)
+	if err != nil {
+		glog.Fatalf("Error setting up endpoints: %v", err)
+	}
+
+	// Collect the expected mailbox states for
+	// verification at the end of the run.
+	verifier := worker.NewVerifier()
+
+	// Open the idle connections before sessions
+	// start appending to their mailboxes.
+	watcher := worker.NewIdleWatcher()
+	if conf.Idle.Connections > 0 {
+		watcher.Start(conf, users)
+	}
+
 	// Start the worker pool.
 	for w := 1; w <= conf.Settings.Threads; w++ {
-		go worker.Worker(w, conf, jobs, logger)
+		go worker.Worker(w, conf, jobs, logger, selector, verifier, watcher)
 	}
 
-	go worker.Generator(conf, jobs, users)
+	go worker.Generator(conf, jobs, selector, endpoints, metadata.Capabilities, metadata.Delimiter)
 
 	// Collect results and write them to disk.
 	for a := 1; a <= conf.Settings.Sessions; a++ {
@@ -135,8 +266,55 @@ func main() {
 		}
 	}
 
-	// TODO comment
-	_, err = logFile.WriteString("]}")
+	// Close the sessions list and record the
+	// time the run ended.
+	jsonEnd, err := json.Marshal(time.Now())
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	_, err = logFile.WriteString("]")
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	// Verify all mailboxes if configured.
+	if conf.Verify.Run {
+
+		divergences := verifier.Verify(conf)
+		for _, divergence := range divergences {
+			glog.Warningf("Mailbox diverged: %s", divergence)
+		}
+
+		jsonDivergences, err := json.Marshal(divergences)
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		_, err = logFile.WriteString(fmt.Sprintf(",\"Divergences\":%s", jsonDivergences))
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	// Record the idle connections and the
+	// notifications they received if configured.
+	if conf.Idle.Connections > 0 {
+
+		summary, notifications := watcher.Stop()
+
+		jsonSummary, err := json.Marshal(summary)
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		_, err = logFile.WriteString(fmt.Sprintf(",\"Idle\":%s,\"Notifications\":[%s]", jsonSummary, strings.Join(notifications, ",")))
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	_, err = logFile.WriteString(fmt.Sprintf(",\"End\":%s}", jsonEnd))
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"net"
+
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Variables
+
+// CommandsSent counts the IMAP commands sent
+// to the server, partitioned by command name.
+var CommandsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "commands_sent_total",
+	Help:      "Number of IMAP commands sent to the server.",
+}, []string{"command"})
+
+// Responses counts the tagged responses received
+// from the server, partitioned by command name and
+// response status (OK, NO, BAD).
+var Responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "responses_total",
+	Help:      "Number of tagged IMAP responses received from the server.",
+}, []string{"command", "status"})
+
+// CommandDuration observes the time between sending
+// a command and receiving its tagged response.
+var CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "benchmark",
+	Name:      "command_duration_seconds",
+	Help:      "Time between sending an IMAP command and receiving its tagged response.",
+	Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+}, []string{"command"})
+
+// BytesSent counts all bytes written to
+// connections with the server.
+var BytesSent = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "sent_bytes_total",
+	Help:      "Number of bytes written to IMAP connections.",
+})
+
+// BytesReceived counts all bytes read from
+// connections with the server.
+var BytesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "received_bytes_total",
+	Help:      "Number of bytes read from IMAP connections.",
+})
+
+// ActiveConnections is the number of currently
+// open connections to the server.
+var ActiveConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "benchmark",
+	Name:      "active_connections",
+	Help:      "Number of currently open IMAP connections.",
+})
+
+// SessionDuration observes the time a session takes
+// from connecting to the server until LOGOUT completed.
+var SessionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "benchmark",
+	Name:      "session_duration_seconds",
+	Help:      "Time from connecting to the server until a session logged out.",
+	Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+})
+
+// Divergences counts the differences found between
+// the expected and the actual state of mailboxes.
+var Divergences = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "divergences_total",
+	Help:      "Number of differences between expected and actual mailbox state.",
+})
+
+// ReplicationLag observes the time between a write
+// completing and its effect becoming visible on a
+// probe replica, partitioned by command name.
+var ReplicationLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "benchmark",
+	Name:      "replication_lag_seconds",
+	Help:      "Time until a write became visible on a probe replica.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"command"})
+
+// ConvergenceTimeouts counts writes whose effect did
+// not become visible on a probe replica in time.
+var ConvergenceTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "convergence_timeouts_total",
+	Help:      "Number of writes that did not become visible on a probe replica in time.",
+}, []string{"command"})
+
+// IdleConnections is the number of connections
+// currently waiting in IDLE.
+var IdleConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "benchmark",
+	Name:      "idle_connections",
+	Help:      "Number of connections currently waiting in IDLE.",
+})
+
+// IdleDropped counts idle connections that
+// failed or were closed by the server.
+var IdleDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "idle_dropped_total",
+	Help:      "Number of idle connections that failed or were closed by the server.",
+})
+
+// NotificationLatency observes the time between an
+// APPEND completing and an idle connection of the
+// same mailbox receiving the untagged EXISTS.
+var NotificationLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "benchmark",
+	Name:      "notification_latency_seconds",
+	Help:      "Time from APPEND completion until an idle connection received EXISTS.",
+	Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+})
+
+// ConnectionFailures counts connections that could not
+// be established, partitioned by the failing phase.
+var ConnectionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "connection_failures_total",
+	Help:      "Number of connections that failed to connect or to log in.",
+}, []string{"phase"})
+
+// ConnectionSwitches counts pooled connections that
+// were taken over by a session of another user.
+var ConnectionSwitches = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "benchmark",
+	Name:      "connection_switches_total",
+	Help:      "Number of pooled connections that were logged in as another user.",
+})
+
+// Structs
+
+// Conn wraps a network connection and accounts
+// all read and written bytes in above counters.
+type Conn struct {
+	net.Conn
+}
+
+// Functions
+
+func init() {
+
+	prometheus.MustRegister(CommandsSent)
+	prometheus.MustRegister(Responses)
+	prometheus.MustRegister(CommandDuration)
+	prometheus.MustRegister(BytesSent)
+	prometheus.MustRegister(BytesReceived)
+	prometheus.MustRegister(ActiveConnections)
+	prometheus.MustRegister(SessionDuration)
+	prometheus.MustRegister(Divergences)
+	prometheus.MustRegister(ReplicationLag)
+	prometheus.MustRegister(ConvergenceTimeouts)
+	prometheus.MustRegister(IdleConnections)
+	prometheus.MustRegister(IdleDropped)
+	prometheus.MustRegister(NotificationLatency)
+	prometheus.MustRegister(ConnectionFailures)
+	prometheus.MustRegister(ConnectionSwitches)
+}
+
+// Serve exposes all registered metrics on the
+// '/metrics' path of an HTTP server listening on
+// the supplied address. It blocks until the server
+// stops and returns the reason.
+func Serve(addr string) error {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// Read reads from the underlying connection and
+// counts the received bytes.
+func (c *Conn) Read(b []byte) (int, error) {
+
+	n, err := c.Conn.Read(b)
+	BytesReceived.Add(float64(n))
+
+	return n, err
+}
+
+// Write writes to the underlying connection and
+// counts the sent bytes.
+func (c *Conn) Write(b []byte) (int, error) {
+
+	n, err := c.Conn.Write(b)
+	BytesSent.Add(float64(n))
+
+	return n, err
+}
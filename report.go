@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"path/filepath"
+
+	"github.com/go-pluto/benchmark/report"
+	"github.com/go-pluto/benchmark/resultlog"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// runReport implements the 'report' subcommand. It renders
+// a self-contained HTML report of a results file.
+func runReport(args []string) {
+
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	outFlag := fs.String("out", "", "Location of the HTML file to write. Defaults to the results file with extension '.html'.")
+	bucketFlag := fs.Duration("bucket", time.Second, "Width of the buckets of the throughput timeline.")
+	pointsFlag := fs.Int("points", 20000, "Maximum number of commands drawn in the latency scatter plot.")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		glog.Fatal("report expects exactly one results file")
+	}
+
+	path := fs.Arg(0)
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	log, err := resultlog.Load(path)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(path, filepath.Ext(path)) + ".html"
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	err = report.Write(file, name, log, *bucketFlag, *pointsFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		glog.Fatal(err)
+	}
+}
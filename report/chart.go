@@ -0,0 +1,258 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+
+	"html/template"
+)
+
+// Variables
+
+// palette holds the colors assigned to series in order.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// Constants
+
+const (
+	chartWidth   = 900.0
+	chartHeight  = 360.0
+	marginLeft   = 70.0
+	marginRight  = 150.0
+	marginTop    = 20.0
+	marginBottom = 45.0
+)
+
+// Structs
+
+// point is one data point of a series.
+type point struct {
+	X float64
+	Y float64
+}
+
+// series is a named set of points drawn in one color.
+type series struct {
+	Name   string
+	Points []point
+}
+
+// tick is a labeled position on an axis.
+type tick struct {
+	Value float64
+	Label string
+}
+
+// chart describes a line or scatter chart
+// that is rendered as inline SVG.
+type chart struct {
+	XLabel  string
+	YLabel  string
+	Series  []series
+	Scatter bool
+	LogY    bool
+
+	// XTicks overrides the linear ticks of the
+	// x axis. XTransform maps data values onto
+	// the x axis and defaults to the identity.
+	XTicks     []tick
+	XTransform func(float64) float64
+}
+
+// Functions
+
+// linearTicks returns about n evenly spaced
+// ticks with round values covering [min, max].
+func linearTicks(min float64, max float64, n int) []tick {
+
+	if max <= min {
+		max = min + 1
+	}
+
+	rawStep := (max - min) / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(rawStep)))
+
+	step := magnitude
+	for _, m := range []float64{1, 2, 5, 10} {
+		if (m * magnitude) >= rawStep {
+			step = m * magnitude
+			break
+		}
+	}
+
+	var ticks []tick
+	for v := math.Ceil(min/step) * step; v <= (max + (step / 1000)); v += step {
+		ticks = append(ticks, tick{Value: v, Label: strconv.FormatFloat(v, 'g', 6, 64)})
+	}
+
+	return ticks
+}
+
+// logTicks returns ticks at all powers of ten within [min, max].
+func logTicks(min float64, max float64) []tick {
+
+	var ticks []tick
+	for e := math.Floor(math.Log10(min)); e <= math.Ceil(math.Log10(max)); e++ {
+		v := math.Pow(10, e)
+		ticks = append(ticks, tick{Value: v, Label: strconv.FormatFloat(v, 'g', 6, 64)})
+	}
+
+	return ticks
+}
+
+// drawable reports whether p can be placed on the chart,
+// i.e. its values are finite and, on a logarithmic y axis,
+// its y value is positive.
+func (c *chart) drawable(p point) bool {
+
+	if math.IsNaN(p.X) || math.IsInf(p.X, 0) || math.IsNaN(p.Y) || math.IsInf(p.Y, 0) {
+		return false
+	}
+
+	return !c.LogY || (p.Y > 0)
+}
+
+// SVG renders the chart as inline SVG element.
+func (c *chart) SVG() template.HTML {
+
+	xTransform := c.XTransform
+	if xTransform == nil {
+		xTransform = func(x float64) float64 { return x }
+	}
+
+	yTransform := func(y float64) float64 { return y }
+	if c.LogY {
+		yTransform = math.Log10
+	}
+
+	// Determine data ranges.
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+
+	for _, s := range c.Series {
+
+		for _, p := range s.Points {
+
+			if !c.drawable(p) {
+				continue
+			}
+
+			minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+			minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+		}
+	}
+
+	if math.IsInf(minX, 1) {
+		return template.HTML("<p><em>No data.</em></p>")
+	}
+
+	var yTicks []tick
+	if c.LogY {
+		yTicks = logTicks(minY, maxY)
+	} else {
+		minY = math.Min(minY, 0)
+		yTicks = linearTicks(minY, maxY, 6)
+	}
+
+	if len(yTicks) == 0 {
+		return template.HTML("<p><em>No data.</em></p>")
+	}
+
+	if c.LogY {
+		minY, maxY = yTicks[0].Value, yTicks[(len(yTicks)-1)].Value
+	} else {
+		maxY = math.Max(maxY, yTicks[(len(yTicks)-1)].Value)
+	}
+
+	xTicks := c.XTicks
+	if xTicks == nil {
+		xTicks = linearTicks(minX, maxX, 8)
+	}
+
+	for _, t := range xTicks {
+		minX, maxX = math.Min(minX, t.Value), math.Max(maxX, t.Value)
+	}
+
+	x0, x1 := xTransform(minX), xTransform(maxX)
+	y0, y1 := yTransform(minY), yTransform(maxY)
+
+	if x1 == x0 {
+		x1 = x0 + 1
+	}
+	if y1 == y0 {
+		y1 = y0 + 1
+	}
+
+	plotWidth := chartWidth - marginLeft - marginRight
+	plotHeight := chartHeight - marginTop - marginBottom
+
+	px := func(x float64) float64 {
+		return marginLeft + (((xTransform(x) - x0) / (x1 - x0)) * plotWidth)
+	}
+
+	py := func(y float64) float64 {
+		return marginTop + plotHeight - (((yTransform(y) - y0) / (y1 - y0)) * plotHeight)
+	}
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif" font-size="11">`, chartWidth, chartHeight)
+
+	// Grid lines and tick labels.
+	for _, t := range yTicks {
+		y := py(t.Value)
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ddd"/>`, marginLeft, y, (marginLeft + plotWidth), y)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`, (marginLeft - 5), (y + 4), template.HTMLEscapeString(t.Label))
+	}
+
+	for _, t := range xTicks {
+		x := px(t.Value)
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ddd"/>`, x, marginTop, x, (marginTop + plotHeight))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x, (marginTop + plotHeight + 15), template.HTMLEscapeString(t.Label))
+	}
+
+	// Axes and their labels.
+	fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="none" stroke="#333"/>`, marginLeft, marginTop, plotWidth, plotHeight)
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, (marginLeft + (plotWidth / 2)), (chartHeight - 8), template.HTMLEscapeString(c.XLabel))
+	fmt.Fprintf(&b, `<text transform="translate(15,%.1f) rotate(-90)" text-anchor="middle">%s</text>`, (marginTop + (plotHeight / 2)), template.HTMLEscapeString(c.YLabel))
+
+	// Data and legend.
+	for i, s := range c.Series {
+
+		color := palette[(i % len(palette))]
+
+		if c.Scatter {
+
+			for _, p := range s.Points {
+
+				if !c.drawable(p) {
+					continue
+				}
+
+				fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="1.5" fill="%s" fill-opacity="0.5"/>`, px(p.X), py(p.Y), color)
+			}
+		} else {
+
+			fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="`, color)
+			for _, p := range s.Points {
+
+				if !c.drawable(p) {
+					continue
+				}
+
+				fmt.Fprintf(&b, "%.1f,%.1f ", px(p.X), py(p.Y))
+			}
+			b.WriteString(`"/>`)
+		}
+
+		ly := marginTop + 10 + (float64(i) * 16)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="10" height="10" fill="%s"/>`, (marginLeft + plotWidth + 15), (ly - 9), color)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f">%s</text>`, (marginLeft + plotWidth + 30), ly, template.HTMLEscapeString(s.Name))
+	}
+
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
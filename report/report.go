@@ -0,0 +1,211 @@
+package report
+
+import (
+	"io"
+	"math"
+	"time"
+
+	"encoding/json"
+	"html/template"
+
+	"github.com/go-pluto/benchmark/resultlog"
+	"github.com/go-pluto/benchmark/stats"
+)
+
+// Variables
+
+// percentiles lists the percentiles that
+// make up a percentile curve.
+var percentiles = []float64{0, 10, 20, 30, 40, 50, 60, 70, 75, 80, 85, 90, 92.5, 95, 96, 97, 98, 99, 99.5, 99.9, 99.95, 99.99}
+
+// page is the template of a complete report.
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>IMAP Benchmark Report: {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: right; }
+th { background: #f0f0f0; }
+pre { background: #f7f7f7; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>IMAP Benchmark Report: {{.Name}}</h1>
+<p>{{.Sessions}} sessions with {{.Commands}} commands from {{.Start}} to {{.End}} ({{.Duration}}).</p>
+
+<h2>Summary</h2>
+<table>
+<tr>{{range .Summary.Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Summary.Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Latency over Time</h2>
+{{if .Sampled}}<p>Showing every {{.Sampled}}th command.</p>{{end}}
+{{.Scatter}}
+
+<h2>Latency Percentiles</h2>
+{{.Percentiles}}
+
+<h2>Throughput ({{.Bucket}} buckets)</h2>
+{{.Throughput}}
+
+{{if .Metadata}}<h2>Run Metadata</h2>
+<pre>{{.Metadata}}</pre>
+{{end}}
+<h2>Configuration</h2>
+<pre>{{.Configuration}}</pre>
+</body>
+</html>
+`))
+
+// Structs
+
+// data is passed to the page template.
+type data struct {
+	Name          string
+	Sessions      int
+	Commands      int
+	Start         string
+	End           string
+	Duration      time.Duration
+	Bucket        time.Duration
+	Sampled       int
+	Summary       *resultlog.Table
+	Scatter       template.HTML
+	Percentiles   template.HTML
+	Throughput    template.HTML
+	Metadata      string
+	Configuration string
+}
+
+// Functions
+
+// percentileAxis maps a percentile onto an axis that
+// stretches the tail, i.e. 90, 99, 99.9 are equidistant.
+func percentileAxis(p float64) float64 {
+	return -math.Log10(1 - (p / 100))
+}
+
+// Write renders a self-contained HTML report of the log to w.
+// At most maxPoints commands are drawn in the latency scatter
+// plot, throughput is calculated in buckets of the given width.
+func Write(w io.Writer, name string, log *resultlog.Log, bucket time.Duration, maxPoints int) error {
+
+	commands := log.Commands()
+	names := resultlog.CommandNames(commands)
+	start, end := log.Span()
+
+	d := data{
+		Name:     name,
+		Sessions: len(log.Sessions),
+		Commands: len(commands),
+		Start:    start.Format(time.RFC3339),
+		End:      end.Format(time.RFC3339),
+		Duration: end.Sub(start).Round(time.Millisecond),
+		Bucket:   bucket,
+		Summary:  resultlog.SummaryTable([]resultlog.Run{{Name: name, Log: log}}),
+	}
+
+	conf, err := json.MarshalIndent(log.Configuration, "", "  ")
+	if err != nil {
+		return err
+	}
+	d.Configuration = string(conf)
+
+	if log.Metadata != nil {
+
+		metadata, err := json.MarshalIndent(log.Metadata, "", "  ")
+		if err != nil {
+			return err
+		}
+		d.Metadata = string(metadata)
+	}
+
+	// Only draw every n-th command if there are
+	// too many to keep the report responsive.
+	stride := 1
+	if (maxPoints > 0) && (len(commands) > maxPoints) {
+		stride = int(math.Ceil(float64(len(commands)) / float64(maxPoints)))
+		d.Sampled = stride
+	}
+
+	scatter := &chart{
+		XLabel:  "time since start (s)",
+		YLabel:  "latency (ms)",
+		Scatter: true,
+		LogY:    true,
+	}
+
+	percentileCurves := &chart{
+		XLabel:     "percentile",
+		YLabel:     "latency (ms)",
+		LogY:       true,
+		XTransform: percentileAxis,
+		XTicks: []tick{
+			{Value: 0, Label: "0%"}, {Value: 90, Label: "90%"}, {Value: 99, Label: "99%"},
+			{Value: 99.9, Label: "99.9%"}, {Value: 99.99, Label: "99.99%"},
+		},
+	}
+
+	throughput := &chart{
+		XLabel: "time since start (s)",
+		YLabel: "commands per second",
+	}
+
+	for _, n := range append(names, "ALL") {
+
+		filter := n
+		if n == "ALL" {
+			filter = ""
+		}
+
+		if n != "ALL" {
+
+			s := series{Name: n}
+			i := 0
+
+			for _, command := range commands {
+
+				if command.Name != n {
+					continue
+				}
+
+				if (i % stride) == 0 {
+					s.Points = append(s.Points, point{
+						X: time.Unix(0, command.Timestamp).Sub(start).Seconds(),
+						Y: float64(command.RespTime) / float64(time.Millisecond),
+					})
+				}
+
+				i++
+			}
+
+			scatter.Series = append(scatter.Series, s)
+		}
+
+		sorted := stats.Sorted(resultlog.Latencies(commands, filter))
+		curve := series{Name: n}
+
+		for _, p := range percentiles {
+			curve.Points = append(curve.Points, point{X: p, Y: stats.Percentile(sorted, p)})
+		}
+
+		percentileCurves.Series = append(percentileCurves.Series, curve)
+
+		timeline := series{Name: n}
+		for i, v := range resultlog.Throughput(commands, filter, start, end, bucket) {
+			timeline.Points = append(timeline.Points, point{X: (time.Duration(i) * bucket).Seconds(), Y: v})
+		}
+
+		throughput.Series = append(throughput.Series, timeline)
+	}
+
+	d.Scatter = scatter.SVG()
+	d.Percentiles = percentileCurves.SVG()
+	d.Throughput = throughput.SVG()
+
+	return page.Execute(w, d)
+}
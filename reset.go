@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/worker"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// runReset implements the 'reset' subcommand. It logs in as
+// every user in the userdb and deletes the folders created
+// by benchmark sessions, optionally including the seeded
+// mailbox, so consecutive runs start from a known baseline.
+func runReset(configFile string, userdbFile string, args []string) {
+
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	dryRunFlag := fs.Bool("dry-run", false, "Only report what would be deleted.")
+	seededFlag := fs.Bool("seeded", false, "Also delete the folders of the [seed] section and all messages in INBOX.")
+	fs.Parse(args)
+
+	// Read configuration from file.
+	conf, err := config.LoadConfig(configFile)
+	if err != nil {
+		glog.Fatalf("Error loading config: %v", err)
+	}
+
+	// Load users from userdb file.
+	users, err := config.LoadUsers(userdbFile, conf.UserDB)
+	if err != nil {
+		glog.Fatalf("Error loading users from '%s' file: %v", userdbFile, err)
+	}
+
+	jobs := make(chan config.User, conf.Settings.Threads)
+	results := make(chan worker.ResetResult, 100)
+
+	// Start the resetter pool.
+	for w := 1; w <= conf.Settings.Threads; w++ {
+		go worker.Resetter(w, conf, jobs, *seededFlag, *dryRunFlag, results)
+	}
+
+	go func() {
+
+		for _, user := range users {
+			jobs <- user
+		}
+
+		close(jobs)
+	}()
+
+	action := "Deleted"
+	if *dryRunFlag {
+		action = "Would delete"
+	}
+
+	failed, folders, messages := 0, 0, 0
+	for a := 1; a <= len(users); a++ {
+
+		result := <-results
+
+		if result.Err != nil {
+			glog.Errorf("Resetting mailbox of user %s failed: %v", result.User, result.Err)
+			failed++
+			continue
+		}
+
+		folders += len(result.Folders)
+		messages += result.Messages
+
+		// Only report users whose mailbox changes.
+		if (len(result.Folders) == 0) && (result.Messages == 0) {
+			continue
+		}
+
+		glog.Infof("%s %d folders and %d messages of user %s: %s", action, len(result.Folders), result.Messages, result.User, strings.Join(result.Folders, " "))
+	}
+
+	glog.Infof("%s %d folders and %d messages of %d users", action, folders, messages, (len(users) - failed))
+
+	if failed > 0 {
+		glog.Fatalf("Resetting failed for %d of %d users", failed, len(users))
+	}
+}
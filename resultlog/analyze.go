@@ -0,0 +1,580 @@
+package resultlog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-pluto/benchmark/stats"
+)
+
+// Structs
+
+// Run is a loaded results log together
+// with a name identifying it in output.
+type Run struct {
+	Name string
+	Log  *Log
+}
+
+// Functions
+
+// formatFloat formats a float with the supplied
+// number of decimals for table output.
+func formatFloat(f float64, decimals int) string {
+	return strconv.FormatFloat(f, 'f', decimals, 64)
+}
+
+// latencyCells returns count, mean and common
+// percentiles of the supplied latencies.
+func latencyCells(latencies []float64) []string {
+
+	sorted := stats.Sorted(latencies)
+
+	return []string{
+		strconv.Itoa(len(sorted)),
+		formatFloat(stats.Mean(sorted), 3),
+		formatFloat(stats.Percentile(sorted, 50), 3),
+		formatFloat(stats.Percentile(sorted, 95), 3),
+		formatFloat(stats.Percentile(sorted, 99), 3),
+	}
+}
+
+// SummaryTable lists latency percentiles and throughput
+// per command and in total for every run.
+func SummaryTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "summary",
+		Title:  "Summary",
+		Header: []string{"run", "command", "count", "mean_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms", "throughput_per_s"},
+	}
+
+	for _, run := range runs {
+
+		commands := run.Log.Commands()
+		start, end := run.Log.Span()
+
+		names := append(CommandNames(commands), "ALL")
+
+		for _, name := range names {
+
+			filter := name
+			if name == "ALL" {
+				filter = ""
+			}
+
+			s := Summarize(commands, filter, end.Sub(start))
+
+			t.Rows = append(t.Rows, []string{
+				run.Name, name, strconv.Itoa(s.Count),
+				formatFloat(s.Mean, 3), formatFloat(s.P50, 3), formatFloat(s.P90, 3),
+				formatFloat(s.P95, 3), formatFloat(s.P99, 3), formatFloat(s.Max, 3),
+				formatFloat(s.Throughput, 2),
+			})
+		}
+	}
+
+	return t
+}
+
+// TimeSeriesTable splits every run into buckets of the supplied
+// width and lists throughput and latencies of commands sent
+// within each bucket. Offsets are relative to the run's start.
+func TimeSeriesTable(runs []Run, width time.Duration) *Table {
+
+	t := &Table{
+		Name:   "timeseries",
+		Title:  fmt.Sprintf("Time Series (%s buckets)", width),
+		Header: []string{"run", "offset_s", "throughput_per_s", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	if width <= 0 {
+		return t
+	}
+
+	for _, run := range runs {
+
+		start, end := run.Log.Span()
+		numBuckets := int(end.Sub(start)/width) + 1
+		buckets := make([][]float64, numBuckets)
+
+		for _, command := range run.Log.Commands() {
+
+			i := int(time.Unix(0, command.Timestamp).Sub(start) / width)
+			if (i >= 0) && (i < numBuckets) {
+				buckets[i] = append(buckets[i], (float64(command.RespTime) / float64(time.Millisecond)))
+			}
+		}
+
+		for i, latencies := range buckets {
+
+			row := []string{
+				run.Name,
+				formatFloat((time.Duration(i) * width).Seconds(), 3),
+				formatFloat((float64(len(latencies)) / width.Seconds()), 2),
+			}
+
+			t.Rows = append(t.Rows, append(row, latencyCells(latencies)...))
+		}
+	}
+
+	return t
+}
+
+// UserTable lists the number of sessions and the
+// latencies of all commands per user of every run.
+func UserTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "users",
+		Title:  "Per User",
+		Header: []string{"run", "user", "sessions", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		sessions := make(map[string]int)
+		commands := make(map[string][]Command)
+		var users []string
+
+		for _, session := range run.Log.Sessions {
+
+			if _, found := sessions[session.User]; !found {
+				users = append(users, session.User)
+			}
+
+			sessions[session.User]++
+			commands[session.User] = append(commands[session.User], session.Commands...)
+		}
+
+		sort.Strings(users)
+
+		for _, user := range users {
+			row := []string{run.Name, user, strconv.Itoa(sessions[user])}
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(commands[user], ""))...))
+		}
+	}
+
+	return t
+}
+
+// SessionLengthTable groups the sessions of every run by
+// their number of commands and lists the average session
+// duration as well as the latencies per group.
+func SessionLengthTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "lengths",
+		Title:  "Per Session Length",
+		Header: []string{"run", "length", "sessions", "mean_session_ms", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		sessions := make(map[int]int)
+		durations := make(map[int]float64)
+		commands := make(map[int][]Command)
+		var lengths []int
+
+		for _, session := range run.Log.Sessions {
+
+			length := len(session.Commands)
+
+			if _, found := sessions[length]; !found {
+				lengths = append(lengths, length)
+			}
+
+			sessions[length]++
+			commands[length] = append(commands[length], session.Commands...)
+
+			if length > 0 {
+				first := session.Commands[0]
+				last := session.Commands[(length - 1)]
+				durations[length] += float64((last.Timestamp + last.RespTime) - first.Timestamp)
+			}
+		}
+
+		sort.Ints(lengths)
+
+		for _, length := range lengths {
+
+			meanDuration := (durations[length] / float64(sessions[length])) / float64(time.Millisecond)
+
+			row := []string{
+				run.Name, strconv.Itoa(length), strconv.Itoa(sessions[length]),
+				formatFloat(meanDuration, 3),
+			}
+
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(commands[length], ""))...))
+		}
+	}
+
+	return t
+}
+
+// ConvergenceTable lists the replication lag of writes
+// per command for every run that polled probe replicas.
+// Writes that did not become visible count as timeouts.
+func ConvergenceTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "convergence",
+		Title:  "Replication Lag",
+		Header: []string{"run", "command", "timeouts", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		lags := make(map[string][]Command)
+		timeouts := make(map[string]int)
+		var names []string
+
+		for _, session := range run.Log.Sessions {
+
+			for _, entry := range session.Convergence {
+
+				if _, found := lags[entry.Name]; !found {
+					names = append(names, entry.Name)
+					lags[entry.Name] = nil
+				}
+
+				if entry.RespTime < 0 {
+					timeouts[entry.Name]++
+					continue
+				}
+
+				lags[entry.Name] = append(lags[entry.Name], entry)
+			}
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			row := []string{run.Name, name, strconv.Itoa(timeouts[name])}
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(lags[name], ""))...))
+		}
+	}
+
+	return t
+}
+
+// EndpointTable lists the number of sessions and the
+// latencies of all commands per endpoint of every run.
+func EndpointTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "endpoints",
+		Title:  "Per Endpoint",
+		Header: []string{"run", "endpoint", "sessions", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		sessions := make(map[string]int)
+		commands := make(map[string][]Command)
+		var endpoints []string
+
+		for _, session := range run.Log.Sessions {
+
+			endpoint := session.Endpoint
+			if (endpoint == "") && (run.Log.Configuration != nil) {
+				endpoint = run.Log.Configuration.Server.Addr
+			}
+
+			if _, found := sessions[endpoint]; !found {
+				endpoints = append(endpoints, endpoint)
+			}
+
+			sessions[endpoint]++
+			commands[endpoint] = append(commands[endpoint], session.Commands...)
+		}
+
+		sort.Strings(endpoints)
+
+		for _, endpoint := range endpoints {
+			row := []string{run.Name, endpoint, strconv.Itoa(sessions[endpoint])}
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(commands[endpoint], ""))...))
+		}
+	}
+
+	return t
+}
+
+// ConnectionTable lists how many sessions reused a connection
+// and how long it took the others to connect, to log in and,
+// if extensions were turned on, to enable them. Sessions of
+// logs that did not record this are skipped.
+func ConnectionTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "connections",
+		Title:  "Connection Setup",
+		Header: []string{"run", "phase", "sessions", "reused", "switched", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		sessions := 0
+		reused := 0
+		switched := 0
+		var connects, logins, enables []Command
+
+		for _, session := range run.Log.Sessions {
+
+			if session.Reused {
+
+				sessions++
+				reused++
+
+				// Switching users only takes a login.
+				if !session.Switched {
+					continue
+				}
+
+				switched++
+				logins = append(logins, Command{Name: "LOGIN", RespTime: session.Login})
+
+				if session.Enable > 0 {
+					enables = append(enables, Command{Name: "ENABLE", RespTime: session.Enable})
+				}
+
+				continue
+			}
+
+			if session.Connect == 0 {
+				continue
+			}
+
+			sessions++
+			connects = append(connects, Command{Name: "CONNECT", RespTime: session.Connect})
+			logins = append(logins, Command{Name: "LOGIN", RespTime: session.Login})
+
+			if session.Enable > 0 {
+				enables = append(enables, Command{Name: "ENABLE", RespTime: session.Enable})
+			}
+		}
+
+		if sessions == 0 {
+			continue
+		}
+
+		for _, phase := range []struct {
+			name     string
+			commands []Command
+		}{{"connect", connects}, {"login", logins}, {"enable", enables}} {
+
+			// Runs without extensions have no enable phase.
+			if (phase.name == "enable") && (len(phase.commands) == 0) {
+				continue
+			}
+
+			row := []string{run.Name, phase.name, strconv.Itoa(sessions), strconv.Itoa(reused), strconv.Itoa(switched)}
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(phase.commands, ""))...))
+		}
+	}
+
+	return t
+}
+
+// LiteralTable lists the latencies of APPEND per kind of
+// literals actually sent for every run, so that non-synchronizing
+// literals can be compared to waiting for continuations.
+// Sessions of logs that did not record this are skipped.
+func LiteralTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "literals",
+		Title:  "APPEND Literals",
+		Header: []string{"run", "literals", "sessions", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		sessions := make(map[string]int)
+		appends := make(map[string][]Command)
+
+		for _, session := range run.Log.Sessions {
+
+			if session.Literals == "" {
+				continue
+			}
+
+			// Logs that did not record the kind of every
+			// APPEND are attributed to the session's kind.
+			used := make(map[string]bool)
+			k := 0
+
+			for _, command := range session.Commands {
+
+				if command.Name != "APPEND" {
+					continue
+				}
+
+				mode := session.Literals
+				if k < len(session.AppendLiterals) {
+					mode = session.AppendLiterals[k]
+				}
+				k++
+
+				used[mode] = true
+				appends[mode] = append(appends[mode], command)
+			}
+
+			for mode := range used {
+				sessions[mode]++
+			}
+		}
+
+		for _, mode := range []string{"sync", "LITERAL-", "LITERAL+"} {
+
+			if sessions[mode] == 0 {
+				continue
+			}
+
+			row := []string{run.Name, mode, strconv.Itoa(sessions[mode])}
+			t.Rows = append(t.Rows, append(row, latencyCells(Latencies(appends[mode], "APPEND"))...))
+		}
+	}
+
+	return t
+}
+
+// NotificationTable lists the idle connections of every run
+// that opened some and the latency of their notifications.
+func NotificationTable(runs []Run) *Table {
+
+	t := &Table{
+		Name:   "notifications",
+		Title:  "Idle Notifications",
+		Header: []string{"run", "connections", "established", "failed", "dropped", "missed", "count", "mean_ms", "p50_ms", "p95_ms", "p99_ms"},
+	}
+
+	for _, run := range runs {
+
+		idle := run.Log.Idle
+		if idle == nil {
+			continue
+		}
+
+		row := []string{
+			run.Name,
+			strconv.Itoa(idle.Connections),
+			strconv.Itoa(idle.Established),
+			strconv.Itoa(idle.Failed),
+			strconv.Itoa(idle.Dropped),
+			strconv.Itoa(idle.Missed),
+		}
+
+		t.Rows = append(t.Rows, append(row, latencyCells(Latencies(run.Log.Notifications, ""))...))
+	}
+
+	return t
+}
+
+// SoakTable splits every soak run into buckets of the supplied
+// width and lists how many connections were open at the end of
+// each bucket, how many were opened, failed and dropped within
+// it, as well as the median connect and login latencies of
+// connections started and the latencies of NOOPs sent within
+// the bucket. Runs without soak connections are skipped.
+func SoakTable(runs []Run, width time.Duration) *Table {
+
+	t := &Table{
+		Name:   "soak",
+		Title:  fmt.Sprintf("Soak (%s buckets)", width),
+		Header: []string{"run", "offset_s", "open", "opened", "failed", "dropped", "connect_p50_ms", "login_p50_ms", "noop_p50_ms", "noop_p99_ms"},
+	}
+
+	if width <= 0 {
+		return t
+	}
+
+	for _, run := range runs {
+
+		if run.Log.Soak == nil {
+			continue
+		}
+
+		var start, end int64
+		for i, session := range run.Log.Sessions {
+
+			if (i == 0) || (session.Start < start) {
+				start = session.Start
+			}
+
+			if session.Closed > end {
+				end = session.Closed
+			}
+		}
+
+		bucket := func(timestamp int64) int {
+			return int(time.Duration(timestamp-start) / width)
+		}
+
+		numBuckets := bucket(end) + 1
+		open := make([]int, numBuckets)
+		opened := make([]int, numBuckets)
+		failed := make([]int, numBuckets)
+		dropped := make([]int, numBuckets)
+		connects := make([][]Command, numBuckets)
+		logins := make([][]Command, numBuckets)
+		noops := make([][]Command, numBuckets)
+
+		for _, session := range run.Log.Sessions {
+
+			if session.Login == 0 {
+
+				if session.Error != "" {
+					failed[bucket(session.Closed)]++
+				}
+
+				continue
+			}
+
+			openedAt := session.Start + session.Connect + session.Login
+
+			opened[bucket(openedAt)]++
+			connects[bucket(session.Start)] = append(connects[bucket(session.Start)], Command{RespTime: session.Connect})
+			logins[bucket(session.Start)] = append(logins[bucket(session.Start)], Command{RespTime: session.Login})
+
+			// Count the connection as open at the end of
+			// every bucket from opening until closing.
+			open[bucket(openedAt)]++
+			open[bucket(session.Closed)]--
+
+			if session.Error != "" {
+				dropped[bucket(session.Closed)]++
+			}
+
+			for _, command := range session.Commands {
+				noops[bucket(command.Timestamp)] = append(noops[bucket(command.Timestamp)], command)
+			}
+		}
+
+		current := 0
+
+		for i := 0; i < numBuckets; i++ {
+
+			current += open[i]
+
+			noopLatencies := stats.Sorted(Latencies(noops[i], ""))
+
+			t.Rows = append(t.Rows, []string{
+				run.Name,
+				formatFloat((time.Duration(i) * width).Seconds(), 3),
+				strconv.Itoa(current),
+				strconv.Itoa(opened[i]),
+				strconv.Itoa(failed[i]),
+				strconv.Itoa(dropped[i]),
+				formatFloat(stats.Percentile(stats.Sorted(Latencies(connects[i], "")), 50), 3),
+				formatFloat(stats.Percentile(stats.Sorted(Latencies(logins[i], "")), 50), 3),
+				formatFloat(stats.Percentile(noopLatencies, 50), 3),
+				formatFloat(stats.Percentile(noopLatencies, 99), 3),
+			})
+		}
+	}
+
+	return t
+}
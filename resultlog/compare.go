@@ -0,0 +1,118 @@
+package resultlog
+
+import (
+	"math"
+	"time"
+
+	"github.com/go-pluto/benchmark/stats"
+)
+
+// Structs
+
+// Summary condenses latencies (in milliseconds) and
+// throughput (in commands per second) of a command.
+type Summary struct {
+	Count      int
+	Mean       float64
+	P50        float64
+	P90        float64
+	P95        float64
+	P99        float64
+	Max        float64
+	Throughput float64
+}
+
+// Delta compares one command between a base and
+// a new run. Latency and throughput are tested for
+// a significant difference by Mann-Whitney U tests,
+// throughput on a per-bucket basis.
+type Delta struct {
+	Command          string
+	Base             Summary
+	New              Summary
+	LatencyChange    float64
+	LatencyTest      stats.MannWhitney
+	ThroughputChange float64
+	ThroughputTest   stats.MannWhitney
+}
+
+// Functions
+
+// Summarize calculates the Summary of all commands
+// with the supplied name. An empty name selects
+// all commands.
+func Summarize(commands []Command, name string, duration time.Duration) Summary {
+
+	sorted := stats.Sorted(Latencies(commands, name))
+
+	summary := Summary{
+		Count: len(sorted),
+		Mean:  stats.Mean(sorted),
+		P50:   stats.Percentile(sorted, 50),
+		P90:   stats.Percentile(sorted, 90),
+		P95:   stats.Percentile(sorted, 95),
+		P99:   stats.Percentile(sorted, 99),
+		Max:   stats.Percentile(sorted, 100),
+	}
+
+	if duration > 0 {
+		summary.Throughput = float64(len(sorted)) / duration.Seconds()
+	}
+
+	return summary
+}
+
+// relativeChange returns (candidate - base) / base.
+func relativeChange(base float64, candidate float64) float64 {
+
+	if base == 0 {
+		return math.NaN()
+	}
+
+	return (candidate - base) / base
+}
+
+// Compare calculates a Delta for every command present
+// in either of the two logs plus an additional entry
+// named "ALL" covering all commands. Throughput samples
+// are taken in buckets of the supplied width.
+func Compare(base *Log, candidate *Log, bucket time.Duration) []Delta {
+
+	baseCommands := base.Commands()
+	newCommands := candidate.Commands()
+
+	baseStart, baseEnd := base.Span()
+	newStart, newEnd := candidate.Span()
+
+	names := CommandNames(append(baseCommands, newCommands...))
+	names = append(names, "ALL")
+
+	deltas := make([]Delta, 0, len(names))
+
+	for _, name := range names {
+
+		filter := name
+		if name == "ALL" {
+			filter = ""
+		}
+
+		d := Delta{
+			Command: name,
+			Base:    Summarize(baseCommands, filter, baseEnd.Sub(baseStart)),
+			New:     Summarize(newCommands, filter, newEnd.Sub(newStart)),
+		}
+
+		d.LatencyChange = relativeChange(d.Base.P50, d.New.P50)
+		d.LatencyTest = stats.MannWhitneyU(Latencies(baseCommands, filter), Latencies(newCommands, filter))
+
+		d.ThroughputChange = relativeChange(d.Base.Throughput, d.New.Throughput)
+		d.ThroughputTest = stats.MannWhitneyU(
+			Throughput(baseCommands, filter, baseStart, baseEnd, bucket),
+			Throughput(newCommands, filter, newStart, newEnd, bucket),
+		)
+
+		deltas = append(deltas, d)
+	}
+
+	return deltas
+}
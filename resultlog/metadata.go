@@ -0,0 +1,82 @@
+package resultlog
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"crypto/sha256"
+	"io/ioutil"
+	"runtime/debug"
+)
+
+// Structs
+
+// Metadata describes the environment a benchmark run
+// was executed in. It is written to the results header
+// so that logs remain self-describing.
+type Metadata struct {
+	Version      string
+	Commit       string
+	Hostname     string
+	GoVersion    string
+	GOMAXPROCS   int
+	NumCPU       int
+	Start        time.Time
+	ServerAddr   string
+	ResolvedAddr string
+	TLSVersion   string
+	TLSCipher    string
+	Capabilities []string
+	Delimiter    string
+	UserDB       string
+	UserDBSHA256 string
+	Seed         int64
+}
+
+// Functions
+
+// NewMetadata collects information about the tool and the
+// host it is running on. Version and commit are taken from
+// the supplied values if set and from the build information
+// embedded by the Go toolchain otherwise.
+func NewMetadata(version string, commit string, userdbFile string, seed int64) (*Metadata, error) {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(userdbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+
+		if version == "" {
+			version = info.Main.Version
+		}
+
+		for _, setting := range info.Settings {
+
+			if (commit == "") && (setting.Key == "vcs.revision") {
+				commit = setting.Value
+			}
+		}
+	}
+
+	return &Metadata{
+		Version:      version,
+		Commit:       commit,
+		Hostname:     hostname,
+		GoVersion:    runtime.Version(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		Start:        time.Now(),
+		UserDB:       userdbFile,
+		UserDBSHA256: fmt.Sprintf("%x", sha256.Sum256(content)),
+		Seed:         seed,
+	}, nil
+}
@@ -0,0 +1,343 @@
+package resultlog
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Structs
+
+// Log represents a complete results file
+// as written by a benchmark run. Divergences
+// are those found by verifying all mailboxes
+// at the end of the run. Idle and Notifications
+// are only set if idle connections were opened,
+// the latter holds the time from APPEND completion
+// until an idle connection received EXISTS as
+// response time.
+type Log struct {
+	Configuration *config.Config
+	Metadata      *Metadata
+	Sessions      []Session
+	Divergences   []string
+	Idle          *Idle
+	Notifications []Command
+	Soak          *Soak
+	End           *time.Time
+}
+
+// Idle counts the idle connections of a run, i.e.
+// those configured, established, failed to establish
+// and dropped later, as well as the appends Missed by
+// all idle connections of their mailbox.
+type Idle struct {
+	Connections int
+	Established int
+	Failed      int
+	Dropped     int
+	Missed      int
+}
+
+// Session is one logged session including
+// all commands that were sent during it. Group
+// and Device are only set for sessions of users
+// with multiple concurrent devices, Divergences
+// only if sessions have been verified. Endpoint is
+// the server address the session was sent to, it is
+// empty in logs of older versions. Convergence
+// holds the replication lag of every write as its
+// response time, -1 if it did not become visible.
+// Connect and Login are the nanoseconds it took to
+// establish the connection and to log in, both zero
+// if the session Reused a connection. Sessions that
+// Switched a reused connection of another user to
+// their own only record Login. Enable is the
+// nanoseconds it took to turn on extensions after
+// logging in, zero if none were enabled. Connections of
+// soak runs also record the times they were opened
+// (Start) and closed (Closed) in nanoseconds and the
+// Error that made them fail, if any. Pipelined is
+// the number of commands sent while others were in
+// flight if pipelining was configured. Literals is
+// the kind of literals APPEND uses on the session's
+// connection, "LITERAL+", "LITERAL-" or "sync", and
+// AppendLiterals the kind every APPEND of Commands
+// actually sent, in order, as LITERAL- falls back to
+// "sync" for messages larger than 4096 bytes.
+type Session struct {
+	SessionID      int
+	User           string
+	Password       string
+	Group          int
+	Device         int
+	Endpoint       string
+	Connect        int64
+	Login          int64
+	Enable         int64
+	Reused         bool
+	Switched       bool
+	Start          int64
+	Closed         int64
+	Error          string
+	Pipelined      int
+	Literals       string
+	AppendLiterals []string
+	Commands       []Command
+	Divergences    []string
+	Convergence    []Command
+}
+
+// Command is one logged IMAP command with the
+// time it was sent and the time it took until
+// the server answered it, both in nanoseconds.
+type Command struct {
+	Timestamp int64
+	Name      string
+	RespTime  int64
+}
+
+// Soak counts the connections of a soak run, i.e. those
+// configured, established, failed to establish and dropped
+// while being kept alive, as well as the Peak number of
+// connections open at the same time. Steps describe how
+// the server behaved as the connection count grew.
+type Soak struct {
+	Connections int
+	Established int
+	Failed      int
+	Dropped     int
+	Peak        int
+	Steps       []SoakStep
+}
+
+// SoakStep describes a soak run while between From and To
+// connections were open: the connections Opened and Failed
+// to open at that count, the median latencies to Connect
+// and Login of those opened, and the number of keepalive
+// Noops sent along with their median and 99th percentile
+// latency. Latencies are in milliseconds.
+type SoakStep struct {
+	From    int
+	To      int
+	Opened  int
+	Failed  int
+	Connect float64
+	Login   float64
+	Noops   int
+	NoopP50 float64
+	NoopP99 float64
+}
+
+// Functions
+
+// UnmarshalJSON decodes a command from its logged
+// form '[timestamp,"NAME",responseTime]'.
+func (c *Command) UnmarshalJSON(data []byte) error {
+
+	var fields []json.RawMessage
+
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) != 3 {
+		return fmt.Errorf("expected 3 fields in command entry but found %d", len(fields))
+	}
+
+	err = json.Unmarshal(fields[0], &c.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid command timestamp: %v", err)
+	}
+
+	err = json.Unmarshal(fields[1], &c.Name)
+	if err != nil {
+		return fmt.Errorf("invalid command name: %v", err)
+	}
+
+	err = json.Unmarshal(fields[2], &c.RespTime)
+	if err != nil {
+		return fmt.Errorf("invalid command response time: %v", err)
+	}
+
+	return nil
+}
+
+// Load reads and decodes the results file at path. Besides
+// the current format, it understands legacy logs that only
+// contain the list of sessions (with or without enclosing
+// brackets) and logs of aborted runs that lack the closing
+// brackets.
+func Load(path string) (*Log, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := decode(bytes.TrimSpace(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode results file '%s': %v", path, err)
+	}
+
+	return log, nil
+}
+
+// decode tries all known results formats on content.
+func decode(content []byte) (*Log, error) {
+
+	log := &Log{}
+
+	switch {
+	case bytes.HasPrefix(content, []byte("[")):
+
+		// Legacy log consisting of an array of sessions.
+		err := json.Unmarshal(content, &log.Sessions)
+		if err != nil {
+			return nil, err
+		}
+
+	case bytes.HasPrefix(content, []byte("{\"SessionID\"")):
+
+		// Legacy log consisting of comma separated
+		// sessions without enclosing brackets.
+		wrapped := append(append([]byte("["), bytes.TrimSuffix(content, []byte(","))...), ']')
+
+		err := json.Unmarshal(wrapped, &log.Sessions)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+
+		err := json.Unmarshal(content, log)
+		if err != nil {
+
+			// Runs that were aborted did not write
+			// the closing brackets, add them and
+			// try again.
+			completed := append(bytes.TrimSuffix(content, []byte(",")), []byte("]}")...)
+			if json.Unmarshal(completed, log) != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return log, nil
+}
+
+// Commands returns all commands of all sessions
+// ordered by the time they were sent.
+func (l *Log) Commands() []Command {
+
+	var commands []Command
+
+	for _, session := range l.Sessions {
+		commands = append(commands, session.Commands...)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Timestamp < commands[j].Timestamp
+	})
+
+	return commands
+}
+
+// Span returns the time the first command was sent
+// and the time the last response was received.
+func (l *Log) Span() (time.Time, time.Time) {
+
+	var first, last int64
+
+	for _, session := range l.Sessions {
+
+		for _, command := range session.Commands {
+
+			if (first == 0) || (command.Timestamp < first) {
+				first = command.Timestamp
+			}
+
+			if (command.Timestamp + command.RespTime) > last {
+				last = command.Timestamp + command.RespTime
+			}
+		}
+	}
+
+	return time.Unix(0, first), time.Unix(0, last)
+}
+
+// Latencies returns the response times in milliseconds
+// of all commands with the supplied name. An empty name
+// selects all commands.
+func Latencies(commands []Command, name string) []float64 {
+
+	var latencies []float64
+
+	for _, command := range commands {
+
+		if (name == "") || (command.Name == name) {
+			latencies = append(latencies, (float64(command.RespTime) / float64(time.Millisecond)))
+		}
+	}
+
+	return latencies
+}
+
+// Throughput counts the commands with the supplied name
+// (or all commands for an empty name) that were sent in
+// each bucket of the given width, starting at start.
+// The counts are returned as commands per second.
+func Throughput(commands []Command, name string, start time.Time, end time.Time, width time.Duration) []float64 {
+
+	if (width <= 0) || !end.After(start) {
+		return nil
+	}
+
+	numBuckets := int(end.Sub(start)/width) + 1
+	buckets := make([]float64, numBuckets)
+
+	for _, command := range commands {
+
+		if (name != "") && (command.Name != name) {
+			continue
+		}
+
+		i := int(time.Unix(0, command.Timestamp).Sub(start) / width)
+		if (i >= 0) && (i < numBuckets) {
+			buckets[i]++
+		}
+	}
+
+	for i := range buckets {
+		buckets[i] = buckets[i] / width.Seconds()
+	}
+
+	return buckets
+}
+
+// CommandNames returns the sorted set of command
+// names occurring in the supplied commands.
+func CommandNames(commands []Command) []string {
+
+	seen := make(map[string]bool)
+	var names []string
+
+	for _, command := range commands {
+
+		if !seen[command.Name] {
+			seen[command.Name] = true
+			names = append(names, command.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
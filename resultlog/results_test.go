@@ -0,0 +1,113 @@
+package resultlog
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Functions
+
+func TestLoad(t *testing.T) {
+
+	session := `{"SessionID":1,"User":"user1","Password":"pw","Commands":[[1000,"LOGIN",20],[2000,"SELECT",30]]}`
+	other := `{"SessionID":2,"User":"user2","Password":"pw","Commands":[[3000,"LOGOUT",40]]}`
+
+	tests := []struct {
+		name     string
+		content  string
+		sessions int
+		commands int
+		metadata bool
+	}{
+		{
+			name:     "current",
+			content:  `{"Metadata":{"Seed":42},"Sessions":[` + session + `,` + other + `],"End":"2017-01-01T00:00:00Z"}`,
+			sessions: 2,
+			commands: 3,
+			metadata: true,
+		},
+		{
+			name:     "legacy array",
+			content:  `[` + session + `,` + other + `]`,
+			sessions: 2,
+			commands: 3,
+		},
+		{
+			name:     "legacy without brackets",
+			content:  session + ",\n" + other + ",\n",
+			sessions: 2,
+			commands: 3,
+		},
+		{
+			name:     "aborted",
+			content:  `{"Metadata":{"Seed":42},"Sessions":[` + session + `,` + "\n" + other + `,` + "\n",
+			sessions: 2,
+			commands: 3,
+			metadata: true,
+		},
+		{
+			name:     "aborted before first session",
+			content:  `{"Metadata":{"Seed":42},"Sessions":[` + "\n",
+			sessions: 0,
+			commands: 0,
+			metadata: true,
+		},
+	}
+
+	for _, test := range tests {
+
+		path := filepath.Join(t.TempDir(), "results.log")
+
+		err := ioutil.WriteFile(path, []byte(test.content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		log, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(log.Sessions) != test.sessions {
+			t.Errorf("%s: got %d sessions, want %d", test.name, len(log.Sessions), test.sessions)
+		}
+
+		if len(log.Commands()) != test.commands {
+			t.Errorf("%s: got %d commands, want %d", test.name, len(log.Commands()), test.commands)
+		}
+
+		if (log.Metadata != nil) != test.metadata {
+			t.Errorf("%s: got metadata %v, want %v", test.name, log.Metadata != nil, test.metadata)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"truncated session", `{"Sessions":[{"SessionID":1,"Commands":[[1000,"LOGIN"`},
+		{"short command", `[{"SessionID":1,"Commands":[[1000,"LOGIN"]]}]`},
+		{"garbage", `not a results file`},
+	}
+
+	for _, test := range tests {
+
+		path := filepath.Join(t.TempDir(), "results.log")
+
+		err := ioutil.WriteFile(path, []byte(test.content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Load(path)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
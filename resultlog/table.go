@@ -0,0 +1,74 @@
+package resultlog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"encoding/csv"
+)
+
+// Structs
+
+// Table is a named set of rows that can be
+// written in different output formats.
+type Table struct {
+	Name   string
+	Title  string
+	Header []string
+	Rows   [][]string
+}
+
+// Functions
+
+// WriteCSV writes the table including its
+// header line as CSV to w.
+func (t *Table) WriteCSV(w io.Writer) error {
+
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(t.Header)
+	if err != nil {
+		return err
+	}
+
+	err = cw.WriteAll(t.Rows)
+	if err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+// WriteMarkdown writes the table preceded by
+// its title as Markdown table to w.
+func (t *Table) WriteMarkdown(w io.Writer) error {
+
+	_, err := fmt.Fprintf(w, "## %s\n\n", t.Title)
+	if err != nil {
+		return err
+	}
+
+	separator := make([]string, len(t.Header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+
+	lines := [][]string{t.Header, separator}
+	lines = append(lines, t.Rows...)
+
+	for _, line := range lines {
+
+		escaped := make([]string, len(line))
+		for i, cell := range line {
+			escaped[i] = strings.Replace(cell, "|", "\\|", -1)
+		}
+
+		_, err := fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
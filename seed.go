@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/worker"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// runSeed implements the 'seed' subcommand. It fills the
+// mailboxes of the users in the userdb with the folders and
+// messages described in the [seed] section of the config,
+// so that benchmarks start out from a realistic state.
+func runSeed(configFile string, userdbFile string, args []string) {
+
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	numFlag := fs.Int("n", 0, "Number of users to seed, starting with the first one in the userdb. 0 seeds all users.")
+	fs.Parse(args)
+
+	// Read configuration from file.
+	conf, err := config.LoadConfig(configFile)
+	if err != nil {
+		glog.Fatalf("Error loading config: %v", err)
+	}
+
+	// Load users from userdb file.
+	users, err := config.LoadUsers(userdbFile, conf.UserDB)
+	if err != nil {
+		glog.Fatalf("Error loading users from '%s' file: %v", userdbFile, err)
+	}
+
+	if (*numFlag > 0) && (*numFlag < len(users)) {
+		users = users[:*numFlag]
+	}
+
+	// Use the configured seed or pick one
+	// if none has been configured.
+	seed := conf.Settings.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed)
+
+	glog.Infof("Seeding mailboxes of %d users with seed %d", len(users), seed)
+
+	jobs := make(chan worker.SeedJob, conf.Settings.Threads)
+	results := make(chan worker.SeedResult, 100)
+
+	// Start the seeder pool.
+	for w := 1; w <= conf.Settings.Threads; w++ {
+		go worker.Seeder(w, conf, jobs, results)
+	}
+
+	go worker.SeedGenerator(conf, users, jobs)
+
+	failed := 0
+	for a := 1; a <= len(users); a++ {
+
+		result := <-results
+
+		if result.Err != nil {
+			glog.Errorf("Seeding mailbox of user %s failed: %v", result.User, result.Err)
+			failed++
+			continue
+		}
+
+		glog.Infof("Seeded mailbox of user %s with %d messages (%d/%d)", result.User, result.Messages, a, len(users))
+	}
+
+	if failed > 0 {
+		glog.Fatalf("Seeding failed for %d of %d users", failed, len(users))
+	}
+}
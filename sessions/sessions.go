@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"strconv"
+	"strings"
 
 	"math/rand"
 
@@ -11,17 +12,26 @@ import (
 // Structs
 
 // IMAPCommand contains the string of the command
-// and the corresponding arguments.
+// and the corresponding arguments. For APPEND, COPY,
+// MOVE and EXPUNGE, Messages is the number of messages
+// the affected folder, i.e. the destination of COPY
+// and MOVE, contains afterwards. Step is the position
+// of the command in the order the commands of all devices
+// of a user were generated in.
 type IMAPCommand struct {
 	Command   string
 	Arguments []string
+	Messages  int
+	Step      int
 }
 
 // Folder represents an IMAP folder including
-// contained messages.
+// contained messages. Subscribed indicates whether
+// the session subscribed to the folder.
 type Folder struct {
 	FolderName string
 	Messages   []Message
+	Subscribed bool
 }
 
 // Message represents a message, in this case
@@ -30,6 +40,37 @@ type Message struct {
 	Flags []string
 }
 
+// Options holds the parameters of generated sessions.
+// Every session contains between MinLength and MaxLength
+// commands. Where every command is allowed, a message is
+// filed into another folder with probability Transfer.
+// Move indicates that the server supports MOVE, otherwise
+// messages are only filed via COPY. New folders are nested
+// with the server's hierarchy Delimiter with probability
+// Nest, below folders with less than Breadth children, up
+// to Depth levels. TreeOps is the probability of a RENAME,
+// SUBSCRIBE or UNSUBSCRIBE instead of any other command.
+type Options struct {
+	MinLength int
+	MaxLength int
+	Transfer  float64
+	Move      bool
+	Delimiter string
+	Depth     int
+	Breadth   int
+	Nest      float64
+	TreeOps   float64
+}
+
+// hierarchy decides where folders of a session are placed.
+// Folders are only nested below or renamed if they are not
+// among the initial folders of the mailbox model, i.e. if
+// they have been generated for the session.
+type hierarchy struct {
+	opts    Options
+	initial map[string]bool
+}
+
 // Functions
 
 // expungeFolder generates an EXPUNGE command and removes
@@ -49,29 +90,132 @@ func expungeFolder(folder *Folder) IMAPCommand {
 	}
 
 	return IMAPCommand{
-		Command: "EXPUNGE",
+		Command:  "EXPUNGE",
+		Messages: len(folder.Messages),
 	}
 }
 
-// createFolder generates a CREATE command with a
-// randomly generated folder name. The newly created
-// folder is appended to the set of folders.
-func createFolder(folders *[]Folder) IMAPCommand {
+// level returns the hierarchy level of folder name,
+// starting at 1 for folders at the top level.
+func (h *hierarchy) level(name string) int {
 
-	var arguments []string
+	if h.opts.Delimiter == "" {
+		return 1
+	}
+
+	return strings.Count(name, h.opts.Delimiter) + 1
+}
+
+// inferior reports whether folder name lies below ancestor.
+func (h *hierarchy) inferior(name string, ancestor string) bool {
+	return (h.opts.Delimiter != "") && strings.HasPrefix(name, (ancestor+h.opts.Delimiter))
+}
+
+// height returns the number of levels of the subtree of
+// folders rooted at name, 1 if name has no inferiors.
+func (h *hierarchy) height(folders []Folder, name string) int {
+
+	height := 1
+
+	for _, folder := range folders {
+
+		if h.inferior(folder.FolderName, name) {
+
+			levels := h.level(folder.FolderName) - h.level(name) + 1
+			if levels > height {
+				height = levels
+			}
+		}
+	}
+
+	return height
+}
+
+// children counts the folders directly below name.
+func (h *hierarchy) children(folders []Folder, name string) int {
+
+	children := 0
+
+	for _, folder := range folders {
 
-	initFolderName := utils.GenerateString(8)
+		if h.inferior(folder.FolderName, name) && (h.level(folder.FolderName) == (h.level(name) + 1)) {
+			children++
+		}
+	}
+
+	return children
+}
+
+// parent chooses the folder a new subtree of the supplied
+// height is placed below, or "" for the top level. With
+// probability Nest, one of the generated folders that leave
+// room for it within Depth and Breadth is chosen. Folders
+// of the subtree rooted at exclude are never chosen.
+func (h *hierarchy) parent(folders []Folder, height int, exclude string) string {
+
+	if (h.opts.Delimiter == "") || (rand.Float64() >= h.opts.Nest) {
+		return ""
+	}
+
+	var candidates []string
+
+	for _, folder := range folders {
+
+		name := folder.FolderName
+
+		if h.initial[name] || (name == "INBOX") || (name == exclude) || h.inferior(name, exclude) {
+			continue
+		}
+
+		if ((h.level(name) + height) > h.opts.Depth) || (h.children(folders, name) >= h.opts.Breadth) {
+			continue
+		}
+
+		candidates = append(candidates, name)
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// name generates a random folder name below parent,
+// or at the top level if parent is empty, that does
+// not exist in the set of folders yet.
+func (h *hierarchy) name(folders []Folder, parent string) string {
+
+	prefix := ""
+	if parent != "" {
+		prefix = parent + h.opts.Delimiter
+	}
+
+	name := prefix + utils.GenerateString(8)
 
 	// Re-generate in case the generated folder name
 	// already exists in this session.
-	for j := 0; j < len(*folders); j++ {
+	for j := 0; j < len(folders); j++ {
 
-		if initFolderName == (*folders)[j].FolderName {
-			initFolderName = utils.GenerateString(8)
+		if name == folders[j].FolderName {
+			name = prefix + utils.GenerateString(8)
 			j = -1
 		}
 	}
 
+	return name
+}
+
+// createFolder generates a CREATE command with a
+// randomly generated folder name, which may be nested
+// below another folder. The newly created folder is
+// appended to the set of folders.
+func createFolder(folders *[]Folder, h *hierarchy) IMAPCommand {
+
+	var arguments []string
+
+	initFolderName := h.name(*folders, h.parent(*folders, 1, ""))
+
 	var messages []Message
 
 	initFolder := Folder{
@@ -91,13 +235,35 @@ func createFolder(folders *[]Folder) IMAPCommand {
 // deleteFolder generates a DELETE command by deleting
 // a random folder from the set of folders. Moreover, the
 // index of the selected folder is adjusted accordingly.
-func deleteFolder(folders *[]Folder, selected *int) IMAPCommand {
+// INBOX and folders with inferiors are never deleted.
+func deleteFolder(folders *[]Folder, selected *int, h *hierarchy) IMAPCommand {
 
 	var arguments []string
 
+	// The selected folder, INBOX and folders with
+	// inferiors cannot be deleted. If only these
+	// exist, create a folder instead.
+	deletable := func(i int) bool {
+
+		name := (*folders)[i].FolderName
+
+		return (i != *selected) && (name != "INBOX") && (h.height(*folders, name) == 1)
+	}
+
+	exists := false
+	for i := range *folders {
+		if deletable(i) {
+			exists = true
+		}
+	}
+
+	if !exists {
+		return createFolder(folders, h)
+	}
+
 	folderIndex := rand.Intn(len(*folders))
 
-	for folderIndex == *selected {
+	for !deletable(folderIndex) {
 		folderIndex = rand.Intn(len(*folders))
 	}
 
@@ -117,6 +283,84 @@ func deleteFolder(folders *[]Folder, selected *int) IMAPCommand {
 	}
 }
 
+// renameFolder generates a RENAME command by choosing a random
+// generated folder and a new name for it, possibly below another
+// parent. Its inferiors are renamed along with it. The selected
+// folder and its ancestors are never renamed. If no folder can
+// be renamed, a folder is created instead.
+func renameFolder(folders *[]Folder, selected int, h *hierarchy) IMAPCommand {
+
+	var arguments []string
+
+	selectedName := ""
+	if selected != -1 {
+		selectedName = (*folders)[selected].FolderName
+	}
+
+	var renamable []string
+	for _, folder := range *folders {
+
+		name := folder.FolderName
+
+		if h.initial[name] || (name == "INBOX") || (name == selectedName) || h.inferior(selectedName, name) {
+			continue
+		}
+
+		renamable = append(renamable, name)
+	}
+
+	if len(renamable) == 0 {
+		return createFolder(folders, h)
+	}
+
+	source := renamable[rand.Intn(len(renamable))]
+	target := h.name(*folders, h.parent(*folders, h.height(*folders, source), source))
+
+	for i := range *folders {
+
+		name := (*folders)[i].FolderName
+
+		if name == source {
+			(*folders)[i].FolderName = target
+		} else if h.inferior(name, source) {
+			(*folders)[i].FolderName = target + strings.TrimPrefix(name, source)
+		}
+	}
+
+	arguments = append(arguments, source)
+	arguments = append(arguments, target)
+
+	return IMAPCommand{
+		Command:   "RENAME",
+		Arguments: arguments,
+	}
+}
+
+// subscribeFolder generates a SUBSCRIBE command for a random
+// folder of the set of folders, or an UNSUBSCRIBE command if
+// the session already subscribed to it.
+func subscribeFolder(folders *[]Folder) IMAPCommand {
+
+	var arguments []string
+
+	folderIndex := rand.Intn(len(*folders))
+	folder := &(*folders)[folderIndex]
+
+	arguments = append(arguments, folder.FolderName)
+
+	command := "SUBSCRIBE"
+	if folder.Subscribed {
+		command = "UNSUBSCRIBE"
+	}
+
+	folder.Subscribed = !folder.Subscribed
+
+	return IMAPCommand{
+		Command:   command,
+		Arguments: arguments,
+	}
+}
+
 // selectFolder generates a SELECT command by choosing a random
 // folder from the set of folders. Moreover, the index of the
 // selected folder is adjusted accordingly.
@@ -152,7 +396,7 @@ func appendMsg(folders *[]Folder) IMAPCommand {
 	arguments = append(arguments, folderName)
 
 	// Generate flags of the message - OPTIONAL.
-	flagsString, flags := utils.GenerateFlags()
+	flagsString, _ := utils.GenerateFlags()
 	arguments = append(arguments, flagsString)
 
 	// TODO: Generate date/time string - OPTIONAL.
@@ -162,11 +406,14 @@ func appendMsg(folders *[]Folder) IMAPCommand {
 	arguments = append(arguments, msgLen)
 	arguments = append(arguments, msg)
 
-	(*folders)[folderIndex].Messages = append((*folders)[folderIndex].Messages, Message{Flags: flags})
+	// The flags are not sent along with the message,
+	// hence it starts out without any flags.
+	(*folders)[folderIndex].Messages = append((*folders)[folderIndex].Messages, Message{})
 
 	return IMAPCommand{
 		Command:   "APPEND",
 		Arguments: arguments,
+		Messages:  len((*folders)[folderIndex].Messages),
 	}
 }
 
@@ -192,162 +439,328 @@ func storeMsg(folder *Folder) IMAPCommand {
 	}
 }
 
-// GenerateSession generates a random sequence of IMAPCommands.
-// The length of the sequence is between minLength and maxLength.
-func GenerateSession(minLength int, maxLength int) []IMAPCommand {
+// transferMsg generates a COPY or, if move is set, a MOVE
+// command by choosing a random message of the selected folder
+// and a random other folder as destination. Either is sent
+// as UID command at random. The message is added to the
+// destination with its flags and, for MOVE, removed from
+// the selected folder.
+func transferMsg(folders *[]Folder, selected int, move bool) IMAPCommand {
 
-	selected := -1
+	var arguments []string
 
-	var commands []IMAPCommand
-	var folders []Folder
+	source := &(*folders)[selected]
 
-	// Define session length.
-	sessionLength := rand.Intn((maxLength - minLength)) + minLength
+	// Select message.
+	msgIndex := rand.Intn(len(source.Messages))
+	arguments = append(arguments, strconv.Itoa((msgIndex + 1)))
 
-	// Generate the session content.
-	for i := 0; i < sessionLength; i++ {
+	// Choose the destination folder.
+	folderIndex := rand.Intn(len(*folders))
+	for folderIndex == selected {
+		folderIndex = rand.Intn(len(*folders))
+	}
+
+	arguments = append(arguments, (*folders)[folderIndex].FolderName)
+
+	flags := make([]string, len(source.Messages[msgIndex].Flags))
+	copy(flags, source.Messages[msgIndex].Flags)
 
-		r := rand.Float64()
+	(*folders)[folderIndex].Messages = append((*folders)[folderIndex].Messages, Message{
+		Flags: flags,
+	})
+
+	command := "COPY"
+	if move {
+		command = "MOVE"
+		source.Messages = append(source.Messages[:msgIndex], source.Messages[(msgIndex+1):]...)
+	}
+
+	if rand.Intn(2) == 0 {
+		command = "UID " + command
+	}
+
+	return IMAPCommand{
+		Command:   command,
+		Arguments: arguments,
+		Messages:  len((*folders)[folderIndex].Messages),
+	}
+}
+
+// nextCommand generates the next IMAP command of a session
+// based on the current state of the mailbox model, i.e. its
+// folders and the index of the folder selected by the session.
+// Folders are placed according to h.
+func nextCommand(folders *[]Folder, selected *int, h *hierarchy) IMAPCommand {
 
-		// The following lines represent the allowed IMAP states
-		// in a session. Based on the current state of the mailbox,
-		// certain IMAP commands might not be allowed, e.g.
-		// a DELETE is only allowed when there are any folders
-		// to be deleted. Hence the following state tree.
+	var command IMAPCommand
 
-		if len(folders) == 0 {
+	// Operations on the folder tree do not depend on
+	// the selected folder and are only generated once
+	// the mailbox contains folders.
+	if (h.opts.TreeOps > 0) && (len(*folders) > 0) && (rand.Float64() < h.opts.TreeOps) {
+
+		if rand.Intn(2) == 0 {
+			return renameFolder(folders, *selected, h)
+		}
+
+		return subscribeFolder(folders)
+	}
+
+	r := rand.Float64()
+
+	// The following lines represent the allowed IMAP states
+	// in a session. Based on the current state of the mailbox,
+	// certain IMAP commands might not be allowed, e.g.
+	// a DELETE is only allowed when there are any folders
+	// to be deleted. Hence the following state tree.
+
+	if len(*folders) == 0 {
+
+		// We begin with the case where the mailbox is empty.
+		// Hence CREATE is the only allowed command.
+		command = createFolder(folders, h)
+	} else {
+
+		// If there are folders in the mailbox, we need
+		// to check whether a folder has been selected.
+		// Depending on the selected state, other commands
+		// might be allowed.
+
+		if *selected == -1 {
+
+			// If the mailbox contains at least one folder and
+			// no folder has been selected by SELECT, we allow
+			// the following commands:
+			// CREATE, DELETE, APPEND, SELECT.
+
+			switch {
+			case 0.0 <= r && r < 0.25:
+				command = createFolder(folders, h)
+			case 0.25 <= r && r < 0.5:
+				command = deleteFolder(folders, selected, h)
+			case 0.5 <= r && r < 0.75:
+				command = appendMsg(folders)
+			case 0.75 <= r && r < 1.0:
+				command = selectFolder(folders, selected)
+			}
 
-			// We begin with the case where the mailbox is empty.
-			// Hence CREATE is the only allowed command.
-			commands = append(commands, createFolder(&folders))
 		} else {
 
-			// If there are folders in the mailbox, we need
-			// to check whether a folder has been selected.
-			// Depending on the selected state, other commands
-			// might be allowed.
-
-			if selected == -1 {
-
-				// If the mailbox contains at least one folder and
-				// no folder has been selected by SELECT, we allow
-				// the following commands:
-				// CREATE, DELETE, APPEND, SELECT.
-
-				switch {
-				case 0.0 <= r && r < 0.25:
-					commands = append(commands, createFolder(&folders))
-				case 0.25 <= r && r < 0.5:
-					commands = append(commands, deleteFolder(&folders, &selected))
-				case 0.5 <= r && r < 0.75:
-					commands = append(commands, appendMsg(&folders))
-				case 0.75 <= r && r < 1.0:
-					commands = append(commands, selectFolder(&folders, &selected))
-				}
+			// In this case the mailbox contains at least one folder
+			// and one of these folders has been selected. Next, we
+			// need to check whether there are other folders in the
+			// mailbox in order to allow or disallow commands like:
+			// DELETE or SELECT.
+
+			if len(*folders) == 1 {
+
+				// In case the mailbox contains only one folder and
+				// this folder is selected, we need to check whether
+				// there are any messages in the folder in order
+				// to allow or disallow the STORE command.
+
+				if len((*folders)[*selected].Messages) == 0 {
+
+					// If there are no messages present in the selected
+					// folder, we only allow the following commands:
+					// CREATE, APPEND, EXPUNGE.
+					switch {
+					case 0.0 <= r && r < 0.3:
+						command = createFolder(folders, h)
+					case 0.3 <= r && r < 0.9:
+						command = appendMsg(folders)
+					case 0.9 <= r && r < 1.0:
+						command = expungeFolder(&(*folders)[*selected])
+					}
+
+				} else {
 
+					// If there are messages in the selected folder,
+					// we can allow STORE as well. Hence the following
+					// commands are allowed in this case:
+					// CREATE, APPEND, STORE, EXPUNGE.
+					switch {
+					case 0.0 <= r && r < 0.25:
+						command = createFolder(folders, h)
+					case 0.25 <= r && r < 0.5:
+						command = appendMsg(folders)
+					case 0.5 <= r && r < 0.75:
+						command = storeMsg(&(*folders)[*selected])
+					case 0.75 <= r && r < 1.0:
+						command = expungeFolder(&(*folders)[*selected])
+					}
+				}
 			} else {
 
-				// In this case the mailbox contains at least one folder
-				// and one of these folders has been selected. Next, we
-				// need to check whether there are other folders in the
-				// mailbox in order to allow or disallow commands like:
-				// DELETE or SELECT.
-
-				if len(folders) == 1 {
-
-					// In case the mailbox contains only one folder and
-					// this folder is selected, we need to check whether
-					// there are any messages in the folder in order
-					// to allow or disallow the STORE command.
-
-					if len(folders[selected].Messages) == 0 {
-
-						// If there are no messages present in the selected
-						// folder, we only allow the following commands:
-						// CREATE, APPEND, EXPUNGE.
-						switch {
-						case 0.0 <= r && r < 0.3:
-							commands = append(commands, createFolder(&folders))
-						case 0.3 <= r && r < 0.9:
-							commands = append(commands, appendMsg(&folders))
-						case 0.9 <= r && r < 1.0:
-							commands = append(commands, expungeFolder(&folders[selected]))
-						}
-
-					} else {
-
-						// If there are messages in the selected folder,
-						// we can allow STORE as well. Hence the following
-						// commands are allowed in this case:
-						// CREATE, APPEND, STORE, EXPUNGE.
-						switch {
-						case 0.0 <= r && r < 0.25:
-							commands = append(commands, createFolder(&folders))
-						case 0.25 <= r && r < 0.5:
-							commands = append(commands, appendMsg(&folders))
-						case 0.5 <= r && r < 0.75:
-							commands = append(commands, storeMsg(&folders[selected]))
-						case 0.75 <= r && r < 1.0:
-							commands = append(commands, expungeFolder(&folders[selected]))
-						}
+				// In this case the mailbox contains more than one
+				// folder and one of these folders is selected.
+				// This represents the case with the most variety of
+				// IMAP commands. Nevertheless we need to check
+				// whether there are messages in the selected folder
+				// in order to allow or disallow the STORE command.
+
+				if len((*folders)[*selected].Messages) == 0 {
+
+					// If there are no messages present, we allow
+					// everything except the STORE command:
+					// CREATE, DELETE, APPEND, SELECT, EXPUNGE.
+					switch {
+					case 0.0 <= r && r < 0.15:
+						command = createFolder(folders, h)
+					case 0.15 <= r && r < 0.3:
+						command = deleteFolder(folders, selected, h)
+					case 0.3 <= r && r < 0.6:
+						command = appendMsg(folders)
+					case 0.6 <= r && r < 0.9:
+						command = selectFolder(folders, selected)
+					case 0.9 <= r && r < 1.0:
+						command = expungeFolder(&(*folders)[*selected])
 					}
 				} else {
 
-					// In this case the mailbox contains more than one
-					// folder and one of these folders is selected.
-					// This represents the case with the most variety of
-					// IMAP commands. Nevertheless we need to check
-					// whether there are messages in the selected folder
-					// in order to allow or disallow the STORE command.
-
-					if len(folders[selected].Messages) == 0 {
-
-						// If there are no messages present, we allow
-						// everything except the STORE command:
-						// CREATE, DELETE, APPEND, SELECT, EXPUNGE.
-						switch {
-						case 0.0 <= r && r < 0.15:
-							commands = append(commands, createFolder(&folders))
-						case 0.15 <= r && r < 0.3:
-							commands = append(commands, deleteFolder(&folders, &selected))
-						case 0.3 <= r && r < 0.6:
-							commands = append(commands, appendMsg(&folders))
-						case 0.6 <= r && r < 0.9:
-							commands = append(commands, selectFolder(&folders, &selected))
-						case 0.9 <= r && r < 1.0:
-							commands = append(commands, expungeFolder(&folders[selected]))
-						}
-					} else {
-
-						// In this case we basically allow every IMAP command:
-						// CREATE, DELETE, APPEND, STORE, SELECT, EXPUNGE.
-						switch {
-						case 0.0 <= r && r < 0.15:
-							commands = append(commands, createFolder(&folders))
-						case 0.15 <= r && r < 0.3:
-							commands = append(commands, deleteFolder(&folders, &selected))
-						case 0.3 <= r && r < 0.5:
-							commands = append(commands, appendMsg(&folders))
-						case 0.5 <= r && r < 0.75:
-							commands = append(commands, storeMsg(&folders[selected]))
-						case 0.75 <= r && r < 0.9:
-							commands = append(commands, selectFolder(&folders, &selected))
-						case 0.9 <= r && r < 1.0:
-							commands = append(commands, expungeFolder(&folders[selected]))
-						}
+					// In this case we basically allow every IMAP command:
+					// CREATE, DELETE, APPEND, STORE, SELECT, EXPUNGE.
+					// If configured, messages are also filed via COPY
+					// or, if supported, MOVE at equal odds.
+					if (h.opts.Transfer > 0) && (rand.Float64() < h.opts.Transfer) {
+						return transferMsg(folders, *selected, (h.opts.Move && (rand.Intn(2) == 0)))
+					}
+
+					switch {
+					case 0.0 <= r && r < 0.15:
+						command = createFolder(folders, h)
+					case 0.15 <= r && r < 0.3:
+						command = deleteFolder(folders, selected, h)
+					case 0.3 <= r && r < 0.5:
+						command = appendMsg(folders)
+					case 0.5 <= r && r < 0.75:
+						command = storeMsg(&(*folders)[*selected])
+					case 0.75 <= r && r < 0.9:
+						command = selectFolder(folders, selected)
+					case 0.9 <= r && r < 1.0:
+						command = expungeFolder(&(*folders)[*selected])
 					}
 				}
 			}
 		}
 	}
 
-	// Select INBOX at the end of the session.
-	var arguments []string
-	arguments = append(arguments, "INBOX")
-	commands = append(commands, IMAPCommand{
-		Command:   "SELECT",
-		Arguments: arguments,
+	return command
+}
+
+// GenerateSession generates a random sequence of IMAPCommands.
+// The length of the sequence is between minLength and maxLength.
+func GenerateSession(minLength int, maxLength int) []IMAPCommand {
+
+	commands, _ := GenerateSessions(nil, 1, Options{
+		MinLength: minLength,
+		MaxLength: maxLength,
 	})
 
-	return commands
+	return commands[0]
+}
+
+// GenerateSessions generates random sequences of IMAPCommands
+// for multiple devices, i.e. connections, of the same user.
+// All sequences operate on one shared mailbox model that
+// starts out with the supplied folders. Their commands are
+// generated in a random interleaving, so that devices work
+// on folders created by other devices. The Step of every
+// command records its position in that interleaving. The
+// length of each sequence is between the minimum and
+// maximum length of opts.
+// The state of the mailbox model after all sequences is
+// returned as well.
+func GenerateSessions(folders []Folder, devices int, opts Options) ([][]IMAPCommand, []Folder) {
+
+	h := &hierarchy{
+		opts:    opts,
+		initial: make(map[string]bool),
+	}
+
+	for _, folder := range folders {
+		h.initial[folder.FolderName] = true
+	}
+
+	commands := make([][]IMAPCommand, devices)
+	selected := make([]int, devices)
+	remaining := make([]int, devices)
+	step := 0
+
+	for d := 0; d < devices; d++ {
+
+		selected[d] = -1
+
+		// Define session length.
+		remaining[d] = rand.Intn((opts.MaxLength - opts.MinLength)) + opts.MinLength
+	}
+
+	// Generate the session content.
+	for {
+
+		var candidates []int
+		for d := 0; d < devices; d++ {
+			if remaining[d] > 0 {
+				candidates = append(candidates, d)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		d := candidates[0]
+		if len(candidates) > 1 {
+			d = candidates[rand.Intn(len(candidates))]
+		}
+
+		// Remember the folders selected by the other
+		// devices as their indices may change.
+		selectedNames := make([]string, devices)
+		for e := 0; e < devices; e++ {
+			if selected[e] != -1 {
+				selectedNames[e] = folders[selected[e]].FolderName
+			}
+		}
+
+		command := nextCommand(&folders, &selected[d], h)
+		command.Step = step
+		step++
+
+		commands[d] = append(commands[d], command)
+		remaining[d]--
+
+		// Re-resolve the other devices' selected folders.
+		// If one was deleted, the device is considered to
+		// have no folder selected anymore.
+		for e := 0; e < devices; e++ {
+
+			if (e == d) || (selected[e] == -1) {
+				continue
+			}
+
+			selected[e] = -1
+			for i := range folders {
+				if folders[i].FolderName == selectedNames[e] {
+					selected[e] = i
+				}
+			}
+		}
+	}
+
+	// Select INBOX at the end of every session.
+	for d := 0; d < devices; d++ {
+
+		var arguments []string
+		arguments = append(arguments, "INBOX")
+		commands[d] = append(commands[d], IMAPCommand{
+			Command:   "SELECT",
+			Arguments: arguments,
+			Step:      step,
+		})
+		step++
+	}
+
+	return commands, folders
 }
@@ -0,0 +1,78 @@
+package sessions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Functions
+
+// flagSet returns flags in canonical form for
+// comparison, i.e. sorted and without \Recent.
+func flagSet(flags []string) string {
+
+	var set []string
+	for _, flag := range flags {
+		if flag != "\\Recent" {
+			set = append(set, flag)
+		}
+	}
+
+	sort.Strings(set)
+
+	return fmt.Sprintf("(%s)", strings.Join(set, " "))
+}
+
+// FlagsEqual reports whether both lists contain the
+// same flags regardless of their order and \Recent.
+func FlagsEqual(a []string, b []string) bool {
+	return flagSet(a) == flagSet(b)
+}
+
+// Diff compares the expected state of a mailbox with its
+// actual state and describes every divergence. Folders of
+// actual that are neither expected nor listed in deleted
+// are ignored, as they may belong to other sessions.
+func Diff(expected []Folder, actual []Folder, deleted []string) []string {
+
+	divergences := make([]string, 0)
+
+	present := make(map[string]Folder)
+	for _, folder := range actual {
+		present[folder.FolderName] = folder
+	}
+
+	for _, name := range deleted {
+
+		if _, ok := present[name]; ok {
+			divergences = append(divergences, fmt.Sprintf("folder %s should have been deleted", name))
+		}
+	}
+
+	for _, want := range expected {
+
+		got, ok := present[want.FolderName]
+		if !ok {
+			divergences = append(divergences, fmt.Sprintf("folder %s is missing", want.FolderName))
+			continue
+		}
+
+		if len(got.Messages) != len(want.Messages) {
+			divergences = append(divergences, fmt.Sprintf("folder %s contains %d messages instead of %d", want.FolderName, len(got.Messages), len(want.Messages)))
+			continue
+		}
+
+		for i := range want.Messages {
+
+			wantFlags := flagSet(want.Messages[i].Flags)
+			gotFlags := flagSet(got.Messages[i].Flags)
+
+			if gotFlags != wantFlags {
+				divergences = append(divergences, fmt.Sprintf("message %d in folder %s has flags %s instead of %s", (i+1), want.FolderName, gotFlags, wantFlags))
+			}
+		}
+	}
+
+	return divergences
+}
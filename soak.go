@@ -0,0 +1,336 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"crypto/tls"
+	"encoding/json"
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/go-pluto/benchmark/resultlog"
+	"github.com/go-pluto/benchmark/stats"
+	"github.com/go-pluto/benchmark/worker"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// peakConnections returns the maximum number of
+// connections that were open at the same time.
+func peakConnections(soakResults []worker.SoakResult) int {
+
+	type event struct {
+		time  int64
+		delta int
+	}
+
+	var events []event
+	for _, result := range soakResults {
+
+		if result.Opened != 0 {
+			events = append(events, event{result.Opened, 1}, event{result.Closed, -1})
+		}
+	}
+
+	// Connections closing at the same time
+	// another one opens do not overlap.
+	sort.Slice(events, func(i, j int) bool {
+
+		if events[i].time == events[j].time {
+			return events[i].delta < events[j].delta
+		}
+
+		return events[i].time < events[j].time
+	})
+
+	open, peak := 0, 0
+	for _, e := range events {
+
+		open += e.delta
+		if open > peak {
+			peak = open
+		}
+	}
+
+	return peak
+}
+
+// soakPercentile returns the p-th percentile of the
+// latencies in milliseconds, 0 if there are none.
+func soakPercentile(latencies []float64, p float64) float64 {
+
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	return stats.Percentile(stats.Sorted(latencies), p)
+}
+
+// soakSteps divides the connection count up to connections
+// into the supplied number of equal ranges and summarizes
+// for each which connections were opened or failed and how
+// fast the server answered NOOPs while as many connections
+// were open.
+func soakSteps(soakResults []worker.SoakResult, connections int, steps int) []resultlog.SoakStep {
+
+	if (connections == 0) || (steps == 0) {
+		return nil
+	}
+
+	var opens, closes []int64
+	for _, result := range soakResults {
+
+		if result.Opened != 0 {
+			opens = append(opens, result.Opened)
+			closes = append(closes, result.Closed)
+		}
+	}
+
+	sort.Slice(opens, func(i, j int) bool { return opens[i] < opens[j] })
+	sort.Slice(closes, func(i, j int) bool { return closes[i] < closes[j] })
+
+	// openAt counts the connections open at timestamp,
+	// excluding those opened at that very moment.
+	openAt := func(timestamp int64) int {
+
+		opened := sort.Search(len(opens), func(i int) bool { return opens[i] >= timestamp })
+		closed := sort.Search(len(closes), func(i int) bool { return closes[i] > timestamp })
+
+		return opened - closed
+	}
+
+	size := (connections + steps - 1) / steps
+
+	step := func(open int) int {
+
+		if (open / size) >= steps {
+			return steps - 1
+		}
+
+		return open / size
+	}
+
+	summaries := make([]resultlog.SoakStep, steps)
+	connects := make([][]float64, steps)
+	logins := make([][]float64, steps)
+	noops := make([][]float64, steps)
+
+	for _, result := range soakResults {
+
+		if result.Opened == 0 {
+			summaries[step(openAt(result.Closed))].Failed++
+			continue
+		}
+
+		s := step(openAt(result.Opened))
+		summaries[s].Opened++
+		connects[s] = append(connects[s], (float64(result.Connect) / float64(time.Millisecond)))
+		logins[s] = append(logins[s], (float64(result.Login) / float64(time.Millisecond)))
+
+		for _, noop := range result.Noops {
+
+			n := step(openAt(noop.Sent))
+			noops[n] = append(noops[n], (float64(noop.RespTime) / float64(time.Millisecond)))
+		}
+	}
+
+	for s := range summaries {
+
+		summaries[s].From = s * size
+		summaries[s].To = ((s + 1) * size) - 1
+		summaries[s].Connect = soakPercentile(connects[s], 50)
+		summaries[s].Login = soakPercentile(logins[s], 50)
+		summaries[s].Noops = len(noops[s])
+		summaries[s].NoopP50 = soakPercentile(noops[s], 50)
+		summaries[s].NoopP99 = soakPercentile(noops[s], 99)
+	}
+
+	summaries[(steps - 1)].To = connections
+
+	return summaries
+}
+
+// runSoak implements the 'soak' subcommand. It opens the
+// number of authenticated connections configured in the
+// [soak] section at the configured rate, keeps all of them
+// alive for a while and records connect and login latencies,
+// failures and keepalive latencies in a results file.
+func runSoak(configFile string, userdbFile string, args []string) {
+
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	connectionsFlag := fs.Int("n", 0, "Number of connections to open. 0 uses the configured number.")
+	fs.Parse(args)
+
+	// Read configuration from file.
+	conf, err := config.LoadConfig(configFile)
+	if err != nil {
+		glog.Fatalf("Error loading config: %v", err)
+	}
+
+	if *connectionsFlag > 0 {
+		conf.Soak.Connections = *connectionsFlag
+	}
+
+	if conf.Soak.Connections == 0 {
+		glog.Fatal("soak expects the number of connections in the [soak] section or via -n")
+	}
+
+	// Expose Prometheus metrics if configured.
+	if conf.Metrics.Addr != "" {
+
+		go func() {
+			glog.Warning(metrics.Serve(conf.Metrics.Addr))
+		}()
+	}
+
+	// Load users from userdb file.
+	users, err := config.LoadUsers(userdbFile, conf.UserDB)
+	if err != nil {
+		glog.Fatalf("Error loading users from '%s' file: %v", userdbFile, err)
+	}
+
+	// Keepalives are spread by the random number
+	// generator, which is seeded as in a benchmark run.
+	seed := pickSeed(conf)
+	rand.Seed(seed)
+
+	// Collect information about this run.
+	metadata, err := resultlog.NewMetadata(version, commit, userdbFile, seed)
+	if err != nil {
+		glog.Fatalf("Error collecting run metadata: %v", err)
+	}
+
+	remoteAddr, tlsState, err := worker.Probe(conf.Server.Addr)
+	if err != nil {
+		glog.Fatalf("Unable to connect to remote server %s: %v", conf.Server.Addr, err)
+	}
+
+	metadata.ServerAddr = conf.Server.Addr
+	metadata.ResolvedAddr = remoteAddr.String()
+	metadata.TLSVersion = tls.VersionName(tlsState.Version)
+	metadata.TLSCipher = tls.CipherSuiteName(tlsState.CipherSuite)
+
+	// Record what the server advertises after login.
+	metadata.Capabilities, metadata.Delimiter, err = worker.ProbeLogin(conf, users[0])
+	if err != nil {
+		glog.Warningf("Unable to query server capabilities: %v", err)
+	}
+
+	jsonConf, err := json.Marshal(conf.Redacted())
+	if err != nil {
+		glog.Fatalf("Error encoding config in JSON: %v", err)
+	}
+
+	jsonMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		glog.Fatalf("Error encoding metadata in JSON: %v", err)
+	}
+
+	logFile, err := config.CreateLog(metadata.Start)
+	if err != nil {
+		glog.Fatalf("Failed to create log file: %v", err)
+	}
+	defer logFile.Close()
+
+	_, err = logFile.WriteString(fmt.Sprintf("{\"Configuration\":%s,\"Metadata\":%s,\"Sessions\":[", jsonConf, jsonMetadata))
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	targets := conf.Server.Targets()
+	stop := make(chan struct{})
+	soakResults := make(chan worker.SoakResult, 100)
+
+	glog.Infof("Opening %d connections at %.1f per second", conf.Soak.Connections, conf.Soak.Rate)
+
+	// Ramp up the connections at the configured rate
+	// and close them once all have been held long enough.
+	go func() {
+
+		start := time.Now()
+		interval := time.Duration(float64(time.Second) / conf.Soak.Rate)
+
+		for i := 0; i < conf.Soak.Connections; i++ {
+
+			time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+
+			user := users[(i % len(users))]
+			addr := targets[(i % len(targets))].Addr
+
+			go worker.SoakConn((i + 1), conf, addr, user, stop, soakResults)
+		}
+
+		glog.Infof("Opened all connections, holding them for %d seconds", conf.Soak.Hold)
+
+		time.Sleep(time.Duration(conf.Soak.Hold) * time.Second)
+		close(stop)
+	}()
+
+	summary := resultlog.Soak{
+		Connections: conf.Soak.Connections,
+	}
+
+	collected := make([]worker.SoakResult, 0, conf.Soak.Connections)
+
+	for a := 1; a <= conf.Soak.Connections; a++ {
+
+		result := <-soakResults
+
+		switch {
+		case result.Opened == 0:
+			summary.Failed++
+		case result.Dropped:
+			summary.Established++
+			summary.Dropped++
+		default:
+			summary.Established++
+		}
+
+		if a != 1 {
+
+			_, err := logFile.WriteString(",")
+			if err != nil {
+				glog.Fatal(err)
+			}
+		}
+
+		_, err := logFile.WriteString(strings.Join(result.Output, ""))
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		result.Output = nil
+		collected = append(collected, result)
+	}
+
+	summary.Peak = peakConnections(collected)
+	summary.Steps = soakSteps(collected, conf.Soak.Connections, conf.Soak.Steps)
+
+	jsonSummary, err := json.Marshal(summary)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	jsonEnd, err := json.Marshal(time.Now())
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	_, err = logFile.WriteString(fmt.Sprintf("],\"Soak\":%s,\"End\":%s}", jsonSummary, jsonEnd))
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	err = logFile.Sync()
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	glog.Infof("Established %d of %d connections, %d failed, %d dropped, at most %d open at the same time", summary.Established, summary.Connections, summary.Failed, summary.Dropped, summary.Peak)
+}
@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Structs
+
+// MannWhitney holds the outcome of a two-sided
+// Mann-Whitney U test between two samples.
+type MannWhitney struct {
+	U float64
+	Z float64
+	P float64
+}
+
+// Functions
+
+// Sorted returns a sorted copy of the sample.
+func Sorted(sample []float64) []float64 {
+
+	sorted := make([]float64, len(sample))
+	copy(sorted, sample)
+	sort.Float64s(sorted)
+
+	return sorted
+}
+
+// Mean returns the arithmetic mean of the sample.
+func Mean(sample []float64) float64 {
+
+	if len(sample) == 0 {
+		return math.NaN()
+	}
+
+	sum := 0.0
+	for _, v := range sample {
+		sum += v
+	}
+
+	return sum / float64(len(sample))
+}
+
+// Percentile returns the p-th percentile (0 <= p <= 100)
+// of an already sorted sample using linear interpolation
+// between the closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+
+	rank := (p / 100.0) * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + ((rank - float64(lower)) * (sorted[upper] - sorted[lower]))
+}
+
+// MannWhitneyU performs a two-sided Mann-Whitney U test on
+// samples a and b. The p-value is calculated from the normal
+// approximation including a correction for ties, which is
+// appropriate for the sample sizes of benchmark runs.
+func MannWhitneyU(a []float64, b []float64) MannWhitney {
+
+	n1 := float64(len(a))
+	n2 := float64(len(b))
+
+	if n1 == 0 || n2 == 0 {
+		return MannWhitney{U: math.NaN(), Z: math.NaN(), P: math.NaN()}
+	}
+
+	type value struct {
+		v     float64
+		first bool
+	}
+
+	values := make([]value, 0, (len(a) + len(b)))
+	for _, v := range a {
+		values = append(values, value{v: v, first: true})
+	}
+	for _, v := range b {
+		values = append(values, value{v: v})
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].v < values[j].v
+	})
+
+	// Assign average ranks to groups of ties and
+	// accumulate the tie correction term.
+	rankSum := 0.0
+	tieTerm := 0.0
+
+	for i := 0; i < len(values); {
+
+		j := i
+		for (j < len(values)) && (values[j].v == values[i].v) {
+			j++
+		}
+
+		rank := (float64(i+1) + float64(j)) / 2.0
+		for k := i; k < j; k++ {
+			if values[k].first {
+				rankSum += rank
+			}
+		}
+
+		t := float64(j - i)
+		tieTerm += (t * t * t) - t
+
+		i = j
+	}
+
+	u1 := rankSum - ((n1 * (n1 + 1)) / 2.0)
+	u2 := (n1 * n2) - u1
+	u := math.Min(u1, u2)
+
+	n := n1 + n2
+	mean := (n1 * n2) / 2.0
+	variance := ((n1 * n2) / 12.0) * ((n + 1) - (tieTerm / (n * (n - 1))))
+
+	if variance <= 0 {
+		return MannWhitney{U: u, Z: 0, P: 1}
+	}
+
+	// Continuity corrected z-score.
+	z := (math.Abs(u1-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+
+	return MannWhitney{
+		U: u,
+		Z: z,
+		P: math.Erfc(z / math.Sqrt2),
+	}
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+// Functions
+
+// approx reports whether a and b are equal up to
+// a small tolerance or both NaN.
+func approx(a float64, b float64) bool {
+
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+
+	return math.Abs(a-b) < 1e-4
+}
+
+func TestPercentile(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 50, math.NaN()},
+		{"single", []float64{7}, 99, 7},
+		{"minimum", []float64{1, 2, 3, 4}, 0, 1},
+		{"maximum", []float64{1, 2, 3, 4}, 100, 4},
+		{"median of even count", []float64{1, 2, 3, 4}, 50, 2.5},
+		{"median of odd count", []float64{1, 2, 3, 4, 5}, 50, 3},
+		{"interpolated", []float64{1, 2, 3, 4}, 90, 3.7},
+	}
+
+	for _, test := range tests {
+
+		got := Percentile(test.sorted, test.p)
+		if !approx(got, test.want) {
+			t.Errorf("%s: Percentile(%v, %v) = %v, want %v", test.name, test.sorted, test.p, got, test.want)
+		}
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a    []float64
+		b    []float64
+		want MannWhitney
+	}{
+		{
+			// U = 3 for samples of 5 and 4, whose exact
+			// two-sided p-value is 0.111.
+			name: "textbook",
+			a:    []float64{19, 22, 16, 29, 24},
+			b:    []float64{20, 11, 17, 12},
+			want: MannWhitney{U: 3, Z: 1.592168, P: 0.111347},
+		},
+		{
+			name: "separated",
+			a:    []float64{1, 2, 3},
+			b:    []float64{4, 5, 6},
+			want: MannWhitney{U: 0, Z: 1.745743, P: 0.080856},
+		},
+		{
+			name: "ties",
+			a:    []float64{1, 2, 2, 3},
+			b:    []float64{2, 3, 4, 5},
+			want: MannWhitney{U: 2.5, Z: 1.488351, P: 0.136658},
+		},
+		{
+			name: "identical",
+			a:    []float64{1, 1},
+			b:    []float64{1, 1},
+			want: MannWhitney{U: 2, Z: 0, P: 1},
+		},
+		{
+			name: "empty first",
+			a:    nil,
+			b:    []float64{1, 2},
+			want: MannWhitney{U: math.NaN(), Z: math.NaN(), P: math.NaN()},
+		},
+		{
+			name: "empty second",
+			a:    []float64{1, 2},
+			b:    nil,
+			want: MannWhitney{U: math.NaN(), Z: math.NaN(), P: math.NaN()},
+		},
+	}
+
+	for _, test := range tests {
+
+		got := MannWhitneyU(test.a, test.b)
+		if !approx(got.U, test.want.U) || !approx(got.Z, test.want.Z) || !approx(got.P, test.want.P) {
+			t.Errorf("%s: MannWhitneyU(%v, %v) = %+v, want %+v", test.name, test.a, test.b, got, test.want)
+		}
+
+		// The test is two-sided, swapping the
+		// samples must not change the outcome.
+		swapped := MannWhitneyU(test.b, test.a)
+		if !approx(swapped.U, got.U) || !approx(swapped.P, got.P) {
+			t.Errorf("%s: swapped samples give %+v, want %+v", test.name, swapped, got)
+		}
+	}
+}
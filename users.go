@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/utils"
+	"github.com/golang/glog"
+)
+
+// Functions
+
+// writeUserFile writes users as passwd-file in
+// the supplied scheme to a new file at path. An
+// existing file is only overwritten if force is set.
+func writeUserFile(path string, users []config.User, scheme string, force bool) {
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	}
+
+	file, err := os.OpenFile(path, flags, 0600)
+	if os.IsExist(err) {
+		glog.Fatalf("%s already exists, pass -force to overwrite it", path)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	err = config.WriteUsers(file, users, scheme)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		glog.Fatal(err)
+	}
+}
+
+// runUsers implements the 'users' subcommand
+// and dispatches to its own subcommands.
+func runUsers(args []string) {
+
+	if len(args) == 0 {
+		glog.Fatal("users expects a subcommand: generate")
+	}
+
+	switch args[0] {
+	case "generate":
+		runUsersGenerate(args[1:])
+	default:
+		glog.Fatalf("Unknown users subcommand '%s'", args[0])
+	}
+}
+
+// runUsersGenerate implements 'users generate'. It creates
+// the configured number of users and writes them to the
+// benchmark's userdb and to a passwd-file for the server
+// under test, so both stay in sync.
+func runUsersGenerate(args []string) {
+
+	fs := flag.NewFlagSet("users generate", flag.ExitOnError)
+	numFlag := fs.Int("n", 100, "Number of users to generate.")
+	startFlag := fs.Int("start", 1, "Number of the first generated user.")
+	patternFlag := fs.String("pattern", "user%d@example.com", "Pattern of user names, %d is replaced by the user's number.")
+	passwordFlag := fs.String("password", "hello123", "Password scheme: 'random', 'template:<template>' with %u, %n, %d replaced as in the userdb config, or a fixed password for all users.")
+	lengthFlag := fs.Int("length", 16, "Length of random passwords.")
+	seedFlag := fs.Int64("seed", 0, "Seed for random passwords, 0 picks one from the current time.")
+	userdbFlag := fs.String("userdb", "userdb.passwd", "Location to write the benchmark's userdb to.")
+	passwdFlag := fs.String("passwd", "", "Location to write the server's passwd-file to, skipped if empty.")
+	schemeFlag := fs.String("scheme", "plain", "Password scheme of the server's passwd-file: plain (pluto, Dovecot) or ssha512 (Dovecot).")
+	forceFlag := fs.Bool("force", false, "Overwrite existing userdb and passwd-file.")
+	fs.Parse(args)
+
+	if *numFlag < 1 {
+		glog.Fatal("n has to be at least 1")
+	}
+
+	if (*schemeFlag != "plain") && (*schemeFlag != "ssha512") {
+		glog.Fatalf("Unknown password scheme '%s', choose plain or ssha512", *schemeFlag)
+	}
+
+	if !strings.Contains(*patternFlag, "%d") {
+		glog.Fatal("pattern has to contain %d to produce distinct user names")
+	}
+
+	// Refuse before writing anything, so that an
+	// existing passwd-file never leaves a new userdb
+	// out of sync with it.
+	if !*forceFlag {
+
+		for _, path := range []string{*userdbFlag, *passwdFlag} {
+
+			if path == "" {
+				continue
+			}
+
+			if _, err := os.Stat(path); err == nil {
+				glog.Fatalf("%s already exists, pass -force to overwrite it", path)
+			}
+		}
+	}
+
+	seed := *seedFlag
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed)
+
+	users := make([]config.User, 0, *numFlag)
+
+	for i := *startFlag; i < (*startFlag + *numFlag); i++ {
+
+		user := config.User{
+			Username: strings.Replace(*patternFlag, "%d", fmt.Sprintf("%d", i), -1),
+		}
+
+		switch {
+		case *passwordFlag == "random":
+			user.Password = utils.GenerateString(*lengthFlag)
+		case strings.HasPrefix(*passwordFlag, "template:"):
+			user.Password = config.ExpandTemplate(strings.TrimPrefix(*passwordFlag, "template:"), user.Username)
+		default:
+			user.Password = *passwordFlag
+		}
+
+		users = append(users, user)
+	}
+
+	writeUserFile(*userdbFlag, users, "plain", *forceFlag)
+
+	if *passwdFlag != "" {
+		writeUserFile(*passwdFlag, users, *schemeFlag, *forceFlag)
+	}
+}
@@ -121,13 +121,20 @@ func GenerateFlags() (string, []string) {
 // GenerateMsg returns a randomly generated message as
 // second value and the message's byte length as first.
 func GenerateMsg() (string, string) {
+	return GenerateMsgLines(10, 512)
+}
+
+// GenerateMsgLines returns a randomly generated message with
+// a body of minLines to maxLines lines as second value and
+// the message's byte length as first.
+func GenerateMsgLines(minLines int, maxLines int) (string, string) {
 
 	// Choose mail version to generate.
 	headerIndex := rand.Intn(5)
 
 	// Generate number of lines of random strings to be
-	// included in this message. 10 <= numLines <= 512.
-	numLines := rand.Intn(503) + 10
+	// included in this message.
+	numLines := rand.Intn((maxLines - minLines + 1)) + minLines
 	includeLines := make([]string, numLines)
 
 	// Generate according number of lines.
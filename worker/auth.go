@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Functions
+
+// saslClient returns the initial response of mechanism for
+// user, nil if the mechanism starts with a server challenge,
+// and a function that computes the response to each challenge.
+// withInitial indicates whether the initial response is sent
+// along with AUTHENTICATE instead of after the first challenge.
+func saslClient(mechanism string, user config.User, withInitial bool) ([]byte, func([]byte) ([]byte, error)) {
+
+	switch mechanism {
+
+	case "PLAIN":
+
+		initial := []byte(fmt.Sprintf("\x00%s\x00%s", user.Username, user.Password))
+
+		sent := withInitial
+		return initial, func(challenge []byte) ([]byte, error) {
+
+			if sent {
+				return nil, fmt.Errorf("unexpected PLAIN challenge: %q", challenge)
+			}
+
+			sent = true
+			return initial, nil
+		}
+
+	case "LOGIN":
+
+		step := 0
+		return nil, func(challenge []byte) ([]byte, error) {
+
+			step++
+
+			// Servers prompt for 'Username:' and 'Password:'
+			// but not all of them use these exact prompts.
+			prompt := strings.ToLower(string(challenge))
+
+			switch {
+			case strings.HasPrefix(prompt, "user"), (step == 1) && !strings.HasPrefix(prompt, "pass"):
+				return []byte(user.Username), nil
+			case strings.HasPrefix(prompt, "pass"), step == 2:
+				return []byte(user.Password), nil
+			}
+
+			return nil, fmt.Errorf("unexpected LOGIN challenge: %q", challenge)
+		}
+
+	case "CRAM-MD5":
+
+		return nil, func(challenge []byte) ([]byte, error) {
+
+			mac := hmac.New(md5.New, []byte(user.Password))
+			mac.Write(challenge)
+
+			return []byte(fmt.Sprintf("%s %s", user.Username, hex.EncodeToString(mac.Sum(nil)))), nil
+		}
+
+	case "XOAUTH2":
+
+		// The password holds the OAuth 2.0 access token.
+		initial := []byte(fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", user.Username, user.Password))
+
+		sent := withInitial
+		return initial, func(challenge []byte) ([]byte, error) {
+
+			// After a failure, the server sends an error
+			// as challenge that is acknowledged by an empty
+			// response before the tagged NO.
+			if sent {
+				return []byte{}, nil
+			}
+
+			sent = true
+			return initial, nil
+		}
+	}
+
+	return nil, func(challenge []byte) ([]byte, error) {
+		return nil, fmt.Errorf("unsupported SASL mechanism '%s'", mechanism)
+	}
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Functions
+
+func TestSASLClient(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		mechanism   string
+		user        config.User
+		withInitial bool
+		initial     string
+		noInitial   bool
+		challenges  []string
+		responses   []string
+		fail        bool
+	}{
+		{
+			name:        "PLAIN with initial response",
+			mechanism:   "PLAIN",
+			user:        config.User{Username: "user1", Password: "secret"},
+			withInitial: true,
+			initial:     "\x00user1\x00secret",
+			challenges:  []string{""},
+			fail:        true,
+		},
+		{
+			name:       "PLAIN after challenge",
+			mechanism:  "PLAIN",
+			user:       config.User{Username: "user1", Password: "secret"},
+			initial:    "\x00user1\x00secret",
+			challenges: []string{""},
+			responses:  []string{"\x00user1\x00secret"},
+		},
+		{
+			name:       "LOGIN",
+			mechanism:  "LOGIN",
+			user:       config.User{Username: "user1", Password: "secret"},
+			noInitial:  true,
+			challenges: []string{"Username:", "Password:"},
+			responses:  []string{"user1", "secret"},
+		},
+		{
+			name:       "LOGIN with other prompts",
+			mechanism:  "LOGIN",
+			user:       config.User{Username: "user1", Password: "secret"},
+			noInitial:  true,
+			challenges: []string{"Name", "Secret"},
+			responses:  []string{"user1", "secret"},
+		},
+		{
+			name:       "LOGIN with extra challenge",
+			mechanism:  "LOGIN",
+			user:       config.User{Username: "user1", Password: "secret"},
+			noInitial:  true,
+			challenges: []string{"Username:", "Password:", "More:"},
+			responses:  []string{"user1", "secret"},
+			fail:       true,
+		},
+		{
+			// Test vector of RFC 2195, section 2.
+			name:       "CRAM-MD5",
+			mechanism:  "CRAM-MD5",
+			user:       config.User{Username: "tim", Password: "tanstaaftanstaaf"},
+			noInitial:  true,
+			challenges: []string{"<1896.697170952@postoffice.reston.mci.net>"},
+			responses:  []string{"tim b913a602c7eda7a495b4e6e7334d3890"},
+		},
+		{
+			name:        "XOAUTH2 with initial response",
+			mechanism:   "XOAUTH2",
+			user:        config.User{Username: "user1@example.com", Password: "token"},
+			withInitial: true,
+			initial:     "user=user1@example.com\x01auth=Bearer token\x01\x01",
+			challenges:  []string{`{"status":"401"}`},
+			responses:   []string{""},
+		},
+		{
+			name:       "XOAUTH2 after challenge",
+			mechanism:  "XOAUTH2",
+			user:       config.User{Username: "user1@example.com", Password: "token"},
+			initial:    "user=user1@example.com\x01auth=Bearer token\x01\x01",
+			challenges: []string{"", `{"status":"401"}`},
+			responses:  []string{"user=user1@example.com\x01auth=Bearer token\x01\x01", ""},
+		},
+		{
+			name:       "unsupported",
+			mechanism:  "GSSAPI",
+			user:       config.User{Username: "user1", Password: "secret"},
+			noInitial:  true,
+			challenges: []string{""},
+			fail:       true,
+		},
+	}
+
+	for _, test := range tests {
+
+		initial, respond := saslClient(test.mechanism, test.user, test.withInitial)
+
+		if test.noInitial && (initial != nil) {
+			t.Errorf("%s: unexpected initial response %q", test.name, initial)
+		} else if !test.noInitial && (string(initial) != test.initial) {
+			t.Errorf("%s: initial response = %q, want %q", test.name, initial, test.initial)
+		}
+
+		for i, challenge := range test.challenges {
+
+			response, err := respond([]byte(challenge))
+
+			if i >= len(test.responses) {
+
+				if !test.fail || (err == nil) {
+					t.Errorf("%s: expected an error for challenge %q but got %q", test.name, challenge, response)
+				}
+				break
+			}
+
+			if err != nil {
+				t.Errorf("%s: unexpected error for challenge %q: %v", test.name, challenge, err)
+				break
+			}
+
+			if string(response) != test.responses[i] {
+				t.Errorf("%s: response to %q = %q, want %q", test.name, challenge, response, test.responses[i])
+			}
+		}
+	}
+}
@@ -3,69 +3,333 @@ package worker
 import (
 	"bufio"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
 	"time"
 
 	"crypto/tls"
+	"encoding/base64"
 
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
 	"github.com/golang/glog"
 )
 
+// Variables
+
+// dialTimeout bounds connecting to a server,
+// including the TLS handshake.
+var dialTimeout = 30 * time.Second
+
 // Structs
 
 // Conn encapsulates connection adapters to write
-// and read from an active TLS connection.
+// and read from an active TLS connection. status
+// is the status of the last tagged response,
+// loginTime the time the user logged in and
+// capabilities those advertised by the server
+// in the current state, nil if not yet known.
 type Conn struct {
-	c *tls.Conn
-	r *bufio.Reader
+	c            net.Conn
+	r            *bufio.Reader
+	status       string
+	loginTime    time.Time
+	capabilities map[string]bool
+}
+
+// pipelined is the outcome of one command sent by
+// sendPipelined: the time it was sent, its response
+// time in nanoseconds and the status of its answer.
+type pipelined struct {
+	sent     int64
+	respTime int64
+	status   string
 }
 
 // Functions
 
-// login sends a LOGIN command with the given
-// username/password combination on given TLS
-// connection.
-func (c *Conn) login(username string, password string, id int) error {
+// responseStatus extracts the status (OK, NO, BAD)
+// from a tagged server response.
+func responseStatus(answer string) string {
+
+	fields := strings.Fields(answer)
+	if len(fields) < 2 {
+		return "UNKNOWN"
+	}
+
+	return strings.ToUpper(fields[1])
+}
+
+// parseCapabilities extracts the capabilities from an untagged
+// CAPABILITY response or a CAPABILITY response code in line.
+// It reports whether line contained any of both.
+func parseCapabilities(line string) (map[string]bool, bool) {
+
+	upper := strings.ToUpper(line)
+
+	var list string
+
+	if i := strings.Index(upper, "[CAPABILITY "); i >= 0 {
+
+		list = upper[(i + len("[CAPABILITY ")):]
+		if end := strings.Index(list, "]"); end >= 0 {
+			list = list[:end]
+		}
+	} else if strings.HasPrefix(upper, "* CAPABILITY ") {
+		list = strings.TrimPrefix(upper, "* CAPABILITY ")
+	} else {
+		return nil, false
+	}
+
+	capabilities := make(map[string]bool)
+	for _, capability := range strings.Fields(list) {
+		capabilities[capability] = true
+	}
+
+	return capabilities, true
+}
+
+// Capabilities returns the capabilities the server
+// advertises in the current state, sorted by name.
+func (c *Conn) Capabilities() ([]string, error) {
+
+	if c.capabilities == nil {
+
+		_, err := c.sendCommand("C", "CAPABILITY")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(c.capabilities))
+	for name := range c.capabilities {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Supports reports whether the server advertises the
+// capability name, e.g. "IDLE" or "AUTH=PLAIN". If the
+// capabilities are not yet known, CAPABILITY is sent.
+func (c *Conn) Supports(name string) bool {
+
+	if c.capabilities == nil {
 
-	okAnswer := fmt.Sprintf("%dX OK", id)
+		_, err := c.sendCommand("C", "CAPABILITY")
+		if err != nil {
+			glog.Warningf("Unable to query capabilities: %v", err)
+			return false
+		}
+	}
+
+	return c.capabilities[strings.ToUpper(name)]
+}
+
+// enable turns on those of the supplied extensions that
+// the server supports via ENABLE and returns them. Nothing
+// is sent if the server does not support ENABLE itself.
+func (c *Conn) enable(id int, extensions []string) ([]string, error) {
+
+	if (len(extensions) == 0) || !c.Supports("ENABLE") {
+		return nil, nil
+	}
+
+	var supported []string
+	for _, extension := range extensions {
+
+		if c.Supports(extension) {
+			supported = append(supported, extension)
+		}
+	}
+
+	if len(supported) == 0 {
+		return nil, nil
+	}
+
+	_, err := c.sendCommand(fmt.Sprintf("%dE", id), fmt.Sprintf("ENABLE %s", strings.Join(supported, " ")))
+	if err != nil {
+		return nil, err
+	}
+
+	return supported, nil
+}
+
+// observe records metrics about a sent command
+// and its tagged answer received from the server.
+func observe(command string, answer string, respTime int64) {
+
+	metrics.CommandsSent.WithLabelValues(command).Inc()
+	metrics.Responses.WithLabelValues(command, responseStatus(answer)).Inc()
+	metrics.CommandDuration.WithLabelValues(command).Observe(float64(respTime) / float64(time.Second))
+}
+
+// dial connects to the IMAP server at addr via TLS,
+// giving up after dialTimeout. All bytes passing the
+// connection are counted.
+func dial(addr string) (*Conn, error) {
+
+	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ActiveConnections.Inc()
+
+	countConn := &metrics.Conn{Conn: tlsConn}
+
+	return &Conn{
+		c: countConn,
+		r: bufio.NewReader(countConn),
+	}, nil
+}
+
+// login consumes the server greeting of a new
+// connection and authenticates user on it.
+func (c *Conn) login(user config.User, auth config.Auth, id int) error {
 
 	// Consume mandatory IMAP greeting.
-	_, err := c.r.ReadString('\n')
+	greeting, err := c.r.ReadString('\n')
 	if err != nil {
 		return fmt.Errorf("error during receiving initial server greeting: %v", err)
 	}
 
-	// Send LOGIN command with parameters.
-	_, err = fmt.Fprintf(c.c, "%dX LOGIN %s %s\r\n", id, username, password)
+	c.capabilities, _ = parseCapabilities(greeting)
+
+	return c.authenticate(user, auth, id)
+}
+
+// relogin switches an authenticated connection to user.
+// It returns to the not authenticated state via
+// UNAUTHENTICATE (RFC 8437) and authenticates user.
+func (c *Conn) relogin(user config.User, auth config.Auth, id int) error {
+
+	_, err := c.sendCommand(fmt.Sprintf("%dU", id), "UNAUTHENTICATE")
 	if err != nil {
-		return fmt.Errorf("sending LOGIN to server failed with: %v", err)
+		return err
 	}
 
-	// Wait for success message.
-	answer, err := c.r.ReadString('\n')
+	return c.authenticate(user, auth, id)
+}
+
+// authenticate logs in user on the connection, either via
+// the LOGIN command or via AUTHENTICATE with the SASL mechanism
+// of the user or, if the user has none, the one of auth.
+func (c *Conn) authenticate(user config.User, auth config.Auth, id int) error {
+
+	tag := fmt.Sprintf("%dX ", id)
+
+	mechanism := user.Mechanism
+	if mechanism == "" {
+		mechanism = auth.Mechanism
+	}
+
+	// User name and password are sent as quoted
+	// strings if they contain special characters.
+	name := "LOGIN"
+	command := fmt.Sprintf("LOGIN %s %s", quoteString(user.Username), quoteString(user.Password))
+
+	var respond func([]byte) ([]byte, error)
+
+	if mechanism != "" {
+
+		var initial []byte
+		initial, respond = saslClient(mechanism, user, auth.InitialResponse)
+
+		name = "AUTHENTICATE"
+		command = fmt.Sprintf("AUTHENTICATE %s", mechanism)
+
+		if auth.InitialResponse && (initial != nil) {
+
+			// An empty initial response is sent as '='.
+			encoded := base64.StdEncoding.EncodeToString(initial)
+			if encoded == "" {
+				encoded = "="
+			}
+
+			command = fmt.Sprintf("%s %s", command, encoded)
+		}
+	}
+
+	// Start time taken here.
+	timeStart := time.Now().UnixNano()
+
+	// Send LOGIN or AUTHENTICATE command.
+	err := c.writeCommand(fmt.Sprintf("%s%s", tag, command))
 	if err != nil {
-		return fmt.Errorf("error receiving answer to LOGIN as user: %v", err)
+		return fmt.Errorf("sending %s to server failed with: %v", name, err)
 	}
 
-	// Check for success indicator in answer.
-	for !strings.Contains(answer, okAnswer) {
+	// Capabilities usually change after logging in,
+	// servers may announce them along with the answer.
+	var capabilities map[string]bool
 
-		nextAnswer, err := c.r.ReadString('\n')
+	// Wait for the tagged answer and answer all
+	// challenges of the SASL exchange on the way.
+	var answer string
+	for !strings.HasPrefix(answer, tag) {
+
+		answer, err = c.r.ReadString('\n')
 		if err != nil {
-			return fmt.Errorf("error during receiving nextAnswer: %v", err)
+			return fmt.Errorf("error receiving answer to %s as user: %v", name, err)
 		}
 
-		answer = nextAnswer
+		if announced, found := parseCapabilities(answer); found {
+			capabilities = announced
+		}
+
+		if !strings.HasPrefix(answer, "+") {
+			continue
+		}
+
+		if respond == nil {
+			return fmt.Errorf("unexpected continuation request in answer to LOGIN: %s", strings.TrimSpace(answer))
+		}
+
+		challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(answer, "+")))
+		if err != nil {
+			return fmt.Errorf("invalid %s challenge: %v", mechanism, err)
+		}
+
+		response, err := respond(challenge)
+		if err != nil {
+
+			// Abort the exchange.
+			fmt.Fprintf(c.c, "*\r\n")
+			return err
+		}
+
+		_, err = fmt.Fprintf(c.c, "%s\r\n", base64.StdEncoding.EncodeToString(response))
+		if err != nil {
+			return fmt.Errorf("sending %s response to server failed with: %v", mechanism, err)
+		}
 	}
 
+	observe(name, answer, (time.Now().UnixNano() - timeStart))
+	c.status = responseStatus(answer)
+
+	// Check for success indicator in answer.
+	if c.status != "OK" {
+		return fmt.Errorf("server rejected %s as user %s: %s", name, user.Username, strings.TrimSpace(answer))
+	}
+
+	c.loginTime = time.Now()
+	c.capabilities = capabilities
+
 	return nil
 }
 
 // sendSimpleCommand sends an IMAP command string
 // on given connection. The time between sending
 // the message and receiving the corresponding
-// confirmation will be measured and returned.
-func (c *Conn) sendSimpleCommand(command string) (int64, error) {
+// confirmation will be measured and returned, and
+// observed under the command's name, e.g. UID COPY.
+func (c *Conn) sendSimpleCommand(name string, command string) (int64, error) {
 
 	okAnswer := strings.Split(command, " ")[0]
 
@@ -74,7 +338,7 @@ func (c *Conn) sendSimpleCommand(command string) (int64, error) {
 	// Start time taken here.
 	timeStart := time.Now().UnixNano()
 
-	_, err := fmt.Fprintf(c.c, "%s\r\n", command)
+	err := c.writeCommand(command)
 	if err != nil {
 		return -1, fmt.Errorf("error during sending: %v", err)
 	}
@@ -100,6 +364,9 @@ func (c *Conn) sendSimpleCommand(command string) (int64, error) {
 	// End time taken here.
 	timeEnd := time.Now().UnixNano()
 
+	observe(name, answer, (timeEnd - timeStart))
+	c.status = responseStatus(answer)
+
 	if !strings.Contains(answer, "OK") {
 		glog.Warningf("server responded unexpectedly to command: %s\n by answer: %s", command, answer)
 	}
@@ -107,47 +374,154 @@ func (c *Conn) sendSimpleCommand(command string) (int64, error) {
 	return (timeEnd - timeStart), nil
 }
 
+// sendPipelined sends all commands before reading any
+// response, like aggressive clients do. The response time
+// of each command is measured from sending it until its
+// tagged answer arrived, in whatever order the server
+// answers. The status of the last command is kept.
+func (c *Conn) sendPipelined(commands []string) ([]pipelined, error) {
+
+	results := make([]pipelined, len(commands))
+	names := make([]string, len(commands))
+	pending := make(map[string]int)
+
+	for i, command := range commands {
+
+		fields := strings.Split(command, " ")
+		pending[fields[0]] = i
+		names[i] = fields[1]
+
+		results[i].sent = time.Now().UnixNano()
+
+		err := c.writeCommand(command)
+		if err != nil {
+			return nil, fmt.Errorf("error during sending: %v", err)
+		}
+	}
+
+	for len(pending) > 0 {
+
+		answer, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("error during receiving after pipelined commands: %v", err)
+		}
+
+		i, found := pending[strings.Split(answer, " ")[0]]
+		if !found {
+			continue
+		}
+
+		delete(pending, strings.Split(answer, " ")[0])
+
+		results[i].respTime = time.Now().UnixNano() - results[i].sent
+		results[i].status = responseStatus(answer)
+
+		observe(names[i], answer, results[i].respTime)
+
+		if results[i].status != "OK" {
+			glog.Warningf("server responded unexpectedly to command: %s\n by answer: %s", commands[i], answer)
+		}
+	}
+
+	c.status = results[len(results)-1].status
+
+	return results, nil
+}
+
+// literalMode returns the kind of literals APPEND sends on
+// the connection: "LITERAL+" or "LITERAL-" if the server
+// advertises the respective extension for non-synchronizing
+// literals (RFC 7888) and nonSync is set, "sync" otherwise.
+func (c *Conn) literalMode(nonSync bool) string {
+
+	if nonSync && c.Supports("LITERAL+") {
+		return "LITERAL+"
+	}
+
+	if nonSync && c.Supports("LITERAL-") {
+		return "LITERAL-"
+	}
+
+	return "sync"
+}
+
 // sendAppendCommand sends an IMAP command string
 // that contains an APPEND command on the given
 // connection "con". The time between the send of
 // the message and the receive of the imap confirmation
-// will be counted and returned.
-func (c *Conn) sendAppendCommand(command string, literal string) (int64, error) {
-
-	glog.V(3).Info("Sending command: ", command)
+// will be counted and returned. Depending on mode, the
+// literal is sent right away as non-synchronizing literal
+// or after any continuation response of the server. The
+// kind of literal actually sent is returned as well, i.e.
+// "sync" for messages too large for LITERAL-.
+func (c *Conn) sendAppendCommand(command string, literal string, mode string) (int64, string, error) {
 
 	okAnswer := strings.Split(command, " ")[0]
 
+	// LITERAL- only allows non-synchronizing
+	// literals of up to 4096 bytes.
+	nonSync := (mode == "LITERAL+") || ((mode == "LITERAL-") && (len(literal) <= 4096))
+	used := "sync"
+	if nonSync {
+		used = mode
+		command = fmt.Sprintf("%s+}", strings.TrimSuffix(command, "}"))
+	}
+
+	glog.V(3).Info("Sending command: ", command)
+
 	// Start time taken here.
 	timeStart := time.Now().UnixNano()
 
-	_, err := fmt.Fprintf(c.c, "%s\r\n", command)
+	err := c.writeCommand(command)
 	if err != nil {
-		return -1, fmt.Errorf("error during sending: %v", err)
+		return -1, "", fmt.Errorf("error during sending: %v", err)
 	}
 
-	answer, err := c.r.ReadString('\n')
-	if err != nil {
-		return -1, fmt.Errorf("error during receiving after append command: %v", err)
-	}
+	if !nonSync {
 
-	glog.V(3).Info("Answer: ", answer)
+		// Wait for the continuation request,
+		// skipping untagged responses.
+		answer, err := c.r.ReadString('\n')
+		for (err == nil) && strings.HasPrefix(answer, "* ") {
+			answer, err = c.r.ReadString('\n')
+		}
 
-	if (answer != "+ OK\r\n") && (answer != "+ Ready for literal data\r\n") {
-		return -1, fmt.Errorf("did not receive continuation command from server")
+		if err != nil {
+			return -1, "", fmt.Errorf("error during receiving after append command: %v", err)
+		}
+
+		glog.V(3).Info("Answer: ", answer)
+
+		// The server may reject the command right away,
+		// e.g. with NO [TRYCREATE] for a missing folder.
+		if strings.HasPrefix(answer, (okAnswer + " ")) {
+
+			respTime := time.Now().UnixNano() - timeStart
+
+			observe("APPEND", answer, respTime)
+			c.status = responseStatus(answer)
+
+			glog.Warningf("server responded unexpectedly to command: %s\n by answer: %s", command, answer)
+
+			return respTime, used, nil
+		}
+
+		if !strings.HasPrefix(answer, "+") {
+			return -1, "", fmt.Errorf("did not receive continuation command from server: %s", strings.TrimSpace(answer))
+		}
 	}
 
 	// Send message literal.
 	_, err = fmt.Fprintf(c.c, "%s\r\n", literal)
 	if err != nil {
-		return -1, fmt.Errorf("sending mail message to server failed with: %v", err)
+		return -1, "", fmt.Errorf("sending mail message to server failed with: %v", err)
 	}
 
 	// c.c.SetReadDeadline(time.Now().Add(20*time.Second))
 
-	answer, err = c.r.ReadString('\n')
+	answer, err := c.r.ReadString('\n')
 	if err != nil {
-		return -1, fmt.Errorf("error during receiving response to APPEND: %v", err)
+		return -1, "", fmt.Errorf("error during receiving response to APPEND: %v", err)
 	}
 
 	glog.V(3).Info("Answer: ", answer)
@@ -156,7 +530,7 @@ func (c *Conn) sendAppendCommand(command string, literal string) (int64, error)
 
 		nextAnswer, err := c.r.ReadString('\n')
 		if err != nil {
-			return -1, fmt.Errorf("error during receiving after append literal: %v", err)
+			return -1, "", fmt.Errorf("error during receiving after append literal: %v", err)
 		}
 
 		answer = nextAnswer
@@ -166,18 +540,27 @@ func (c *Conn) sendAppendCommand(command string, literal string) (int64, error)
 	// End time taken here.
 	timeEnd := time.Now().UnixNano()
 
+	observe("APPEND", answer, (timeEnd - timeStart))
+	c.status = responseStatus(answer)
+
 	if !strings.Contains(answer, "OK") {
 		glog.Warningf("server responded unexpectedly to command: %s", command)
 	}
 
-	return (timeEnd - timeStart), nil
+	return (timeEnd - timeStart), used, nil
 }
 
-// logout sends a LOGOUT command to the server.
+// logout sends a LOGOUT command to the server. The
+// connection is closed afterwards, even if LOGOUT fails.
 func (c *Conn) logout(id int) error {
 
+	defer c.close()
+
 	okAnswer := fmt.Sprintf("%dZ", id)
 
+	// Start time taken here.
+	timeStart := time.Now().UnixNano()
+
 	_, err := fmt.Fprintf(c.c, "%dZ LOGOUT\r\n", id)
 	if err != nil {
 		return fmt.Errorf("error during LOGOUT: %v", err)
@@ -197,6 +580,15 @@ func (c *Conn) logout(id int) error {
 
 		answer = nextAnswer
 	}
-	c.c.Close()
+
+	observe("LOGOUT", answer, (time.Now().UnixNano() - timeStart))
+
 	return nil
 }
+
+// close closes the connection without logging out.
+func (c *Conn) close() {
+
+	metrics.ActiveConnections.Dec()
+	c.c.Close()
+}
@@ -0,0 +1,380 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/go-pluto/benchmark/sessions"
+)
+
+// Variables
+
+// writeCommands lists the commands whose effect
+// is polled for on probe replicas.
+var writeCommands = map[string]bool{
+	"CREATE":   true,
+	"DELETE":   true,
+	"APPEND":   true,
+	"STORE":    true,
+	"EXPUNGE":  true,
+	"COPY":     true,
+	"MOVE":     true,
+	"UID COPY": true,
+	"UID MOVE": true,
+	"RENAME":   true,
+}
+
+// Structs
+
+// probe is a connection to a replica that is polled
+// for the changes written via another connection.
+// Writes are kept in pending until they are visible
+// and polled in the background, their lags are
+// collected in log.
+type probe struct {
+	conn    *Conn
+	id      int
+	tag     int
+	lock    sync.Mutex
+	arrived *sync.Cond
+	pending []*write
+	closed  bool
+	log     []string
+	done    chan struct{}
+}
+
+// write is a successful write of a session that is
+// awaited on the replica. It was sent at sent and
+// completed at completed. mailbox is its argument,
+// i.e. the destination of COPY, MOVE and RENAME,
+// source the folder RENAME renamed and selected the
+// folder selected at the time. A write is overtaken
+// if a later write may have reverted its effect and
+// finished once it is no longer polled for.
+type write struct {
+	command   sessions.IMAPCommand
+	mailbox   string
+	source    string
+	selected  string
+	sent      int64
+	completed time.Time
+	overtaken bool
+	finished  bool
+}
+
+// Functions
+
+// dialProbe connects to the probe replica of worker id
+// and logs in as user.
+func dialProbe(id int, conf *config.Config, user config.User) (*probe, error) {
+
+	probes := conf.Server.Probes()
+	addr := probes[((id - 1) % len(probes))]
+
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to probe replica %s: %v", addr, err)
+	}
+
+	err = conn.login(user, conf.Auth, id)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	p := &probe{
+		conn: conn,
+		id:   id,
+		done: make(chan struct{}),
+	}
+	p.arrived = sync.NewCond(&p.lock)
+
+	go p.watch(conf)
+
+	return p, nil
+}
+
+// submit hands w to the probe without waiting for it
+// to become visible. Pending writes w may revert are
+// marked as overtaken.
+func (p *probe) submit(w write) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, earlier := range p.pending {
+		if undoes(w, *earlier) {
+			earlier.overtaken = true
+		}
+	}
+
+	p.pending = append(p.pending, &w)
+	p.arrived.Signal()
+}
+
+// watch polls the replica for all pending writes every
+// interval until the probe is finished. Sessions thereby
+// continue while the replica is polled, and a write does
+// not wait for earlier ones that are slow to arrive.
+// Writes overtaken by a later write are not recorded.
+func (p *probe) watch(conf *config.Config) {
+
+	interval := time.Duration(conf.Convergence.Interval) * time.Millisecond
+
+	for {
+
+		p.lock.Lock()
+
+		for (len(p.pending) == 0) && !p.closed {
+			p.arrived.Wait()
+		}
+
+		if len(p.pending) == 0 {
+			p.lock.Unlock()
+			break
+		}
+
+		writes := append([]*write(nil), p.pending...)
+
+		p.lock.Unlock()
+
+		for _, w := range writes {
+
+			err := p.poll(conf, w)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		p.lock.Lock()
+
+		var remaining []*write
+		for _, w := range p.pending {
+			if !w.finished {
+				remaining = append(remaining, w)
+			}
+		}
+		p.pending = remaining
+
+		p.lock.Unlock()
+
+		if len(remaining) > 0 {
+			time.Sleep(interval)
+		}
+	}
+
+	close(p.done)
+}
+
+// finish waits until all writes handed to the probe
+// have been awaited and returns their lags in the
+// format of the session's Convergence field.
+func (p *probe) finish() []string {
+
+	p.lock.Lock()
+	p.closed = true
+	p.arrived.Signal()
+	p.lock.Unlock()
+
+	<-p.done
+
+	return p.log
+}
+
+// within reports whether folder name is folder or,
+// as generated names of nested folders extend the
+// names of their ancestors, lies below it.
+func within(name string, folder string) bool {
+	return (folder != "") && strings.HasPrefix(name, folder)
+}
+
+// undoes reports whether later, sent after w, may revert
+// the effect w is awaited for, so that the replica might
+// never show it.
+func undoes(later write, w write) bool {
+
+	// Folders that are deleted or renamed take
+	// their contents and inferiors along.
+	removed := ""
+	switch later.command.Command {
+	case "DELETE":
+		removed = later.mailbox
+	case "RENAME":
+		removed = later.source
+	}
+
+	target := w.mailbox
+	if (w.command.Command == "STORE") || (w.command.Command == "EXPUNGE") {
+		target = w.selected
+	}
+
+	if within(target, removed) {
+		return true
+	}
+
+	switch w.command.Command {
+
+	case "DELETE":
+		return ((later.command.Command == "CREATE") || (later.command.Command == "RENAME")) && (later.mailbox == w.mailbox)
+
+	case "APPEND", "COPY", "MOVE", "UID COPY", "UID MOVE":
+		return ((later.command.Command == "EXPUNGE") || strings.HasSuffix(later.command.Command, "MOVE")) && (later.selected == w.mailbox)
+
+	case "EXPUNGE":
+		return ((later.command.Command == "APPEND") || strings.HasSuffix(later.command.Command, "COPY") || strings.HasSuffix(later.command.Command, "MOVE")) && (later.mailbox == w.selected)
+
+	case "STORE":
+		return ((later.command.Command == "STORE") || (later.command.Command == "EXPUNGE") || strings.HasSuffix(later.command.Command, "MOVE")) && (later.selected == w.selected)
+	}
+
+	return false
+}
+
+// nextTag returns a new tag for a command of the probe.
+func (p *probe) nextTag() string {
+	p.tag++
+	return fmt.Sprintf("%dP%d", p.id, p.tag)
+}
+
+// exists reports whether mailbox is listed on the replica.
+func (p *probe) exists(mailbox string) (bool, error) {
+
+	lists, err := p.conn.sendCommand(p.nextTag(), fmt.Sprintf("LIST \"\" %s", quoteString(mailbox)))
+	if err != nil {
+		return false, err
+	}
+
+	for _, list := range lists {
+
+		_, _, name, err := parseList(list)
+		if (err == nil) && (name == mailbox) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// flags returns the flags of message seq in mailbox.
+func (p *probe) flags(mailbox string, seq string) ([]string, error) {
+
+	_, err := p.conn.sendCommand(p.nextTag(), fmt.Sprintf("EXAMINE %s", quoteString(mailbox)))
+	if err != nil {
+		return nil, err
+	}
+
+	fetches, err := p.conn.sendCommand(p.nextTag(), fmt.Sprintf("FETCH %s (FLAGS)", seq))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.conn.sendCommand(p.nextTag(), "CLOSE")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fetch := range fetches {
+
+		m := fetchFlags.FindStringSubmatch(fetch)
+		if (m != nil) && (m[1] == seq) {
+			return strings.Fields(m[2]), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// visible reports whether the effect of command, sent with
+// mailbox as argument while selected was selected, can be
+// observed on the replica. For COPY, MOVE and RENAME,
+// mailbox is the destination. As other devices or clients
+// may add or remove messages meanwhile, a folder only needs
+// to contain at least as many messages as after an added
+// message and at most as many as after an EXPUNGE. Commands
+// the replica rejects, e.g. because it does not know the
+// mailbox yet, count as not visible.
+func (p *probe) visible(command sessions.IMAPCommand, mailbox string, selected string) (bool, error) {
+
+	visible := true
+	var err error
+
+	switch command.Command {
+
+	case "CREATE", "RENAME":
+		visible, err = p.exists(mailbox)
+
+	case "DELETE":
+		visible, err = p.exists(mailbox)
+		visible = !visible
+
+	case "APPEND", "COPY", "MOVE", "UID COPY", "UID MOVE":
+
+		var numMessages int
+		numMessages, err = p.conn.countMessages(p.nextTag(), mailbox)
+		visible = numMessages >= command.Messages
+
+	case "EXPUNGE":
+
+		var numMessages int
+		numMessages, err = p.conn.countMessages(p.nextTag(), selected)
+		visible = numMessages <= command.Messages
+
+	case "STORE":
+
+		var flags []string
+		flags, err = p.flags(selected, command.Arguments[0])
+		visible = sessions.FlagsEqual(flags, strings.Fields(strings.Trim(command.Arguments[1], "()")))
+	}
+
+	if (err != nil) && (p.conn.status == "NO") {
+		return false, nil
+	}
+
+	return visible, err
+}
+
+// poll checks once whether the effect of w is visible on
+// the replica. If so, it records the time since w completed
+// in nanoseconds. Writes that did not become visible within
+// the timeout are recorded with -1, overtaken ones are given
+// up on without being recorded. In all these cases, w is
+// marked as finished.
+func (p *probe) poll(conf *config.Config, w *write) error {
+
+	timeout := time.Duration(conf.Convergence.Timeout) * time.Millisecond
+
+	visible, err := p.visible(w.command, w.mailbox, w.selected)
+	if err != nil {
+		return err
+	}
+
+	lag := time.Since(w.completed)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if visible {
+		metrics.ReplicationLag.WithLabelValues(w.command.Command).Observe(lag.Seconds())
+		p.log = append(p.log, fmt.Sprintf("[%d,\"%s\",%d]", w.sent, w.command.Command, lag.Nanoseconds()))
+		w.finished = true
+		return nil
+	}
+
+	if w.overtaken {
+		w.finished = true
+		return nil
+	}
+
+	if lag > timeout {
+		metrics.ConvergenceTimeouts.WithLabelValues(w.command.Command).Inc()
+		p.log = append(p.log, fmt.Sprintf("[%d,\"%s\",-1]", w.sent, w.command.Command))
+		w.finished = true
+	}
+
+	return nil
+}
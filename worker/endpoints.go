@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"fmt"
+
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Structs
+
+// EndpointSelector decides which endpoint the next session
+// is sent to. Endpoints are chosen in weighted round-robin
+// order, at random in proportion to their weights, or
+// sticky per user so that all sessions of a user end up
+// at the same endpoint.
+type EndpointSelector struct {
+	endpoints []config.Endpoint
+	total     int
+	pick      func(user string) int
+}
+
+// Functions
+
+// NewEndpointSelector creates an EndpointSelector for the
+// write endpoints according to the configured policy.
+func NewEndpointSelector(conf *config.Config) (*EndpointSelector, error) {
+
+	s := &EndpointSelector{
+		endpoints: conf.Server.Targets(),
+	}
+
+	for _, endpoint := range s.endpoints {
+		s.total += endpoint.Weight
+	}
+
+	if s.total == 0 {
+		return nil, fmt.Errorf("no endpoint with positive weight to send sessions to")
+	}
+
+	switch conf.Server.Policy {
+	case "", "roundrobin":
+
+		// Smooth weighted round-robin spreads the
+		// sessions of heavier endpoints evenly.
+		current := make([]int, len(s.endpoints))
+		s.pick = func(user string) int {
+
+			best := 0
+			for i, endpoint := range s.endpoints {
+
+				current[i] += endpoint.Weight
+				if current[i] > current[best] {
+					best = i
+				}
+			}
+
+			current[best] -= s.total
+
+			return best
+		}
+
+	case "sticky":
+
+		s.pick = func(user string) int {
+
+			h := fnv.New32a()
+			h.Write([]byte(user))
+
+			return s.weighted(int(h.Sum32() % uint32(s.total)))
+		}
+
+	case "random":
+
+		s.pick = func(user string) int {
+			return s.weighted(rand.Intn(s.total))
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown endpoint policy '%s', choose roundrobin, sticky or random", conf.Server.Policy)
+	}
+
+	return s, nil
+}
+
+// weighted returns the index of the endpoint that
+// position falls into when every endpoint occupies
+// as many positions as its weight.
+func (s *EndpointSelector) weighted(position int) int {
+
+	for i, endpoint := range s.endpoints {
+
+		if position < endpoint.Weight {
+			return i
+		}
+
+		position -= endpoint.Weight
+	}
+
+	return len(s.endpoints) - 1
+}
+
+// Pick returns the address of the endpoint the next
+// session of user is sent to. It is not safe for
+// concurrent use.
+func (s *EndpointSelector) Pick(user string) string {
+	return s.endpoints[s.pick(user)].Addr
+}
@@ -1,7 +1,7 @@
 package worker
 
 import (
-	"math/rand"
+	"fmt"
 
 	"github.com/go-pluto/benchmark/config"
 	"github.com/go-pluto/benchmark/sessions"
@@ -9,23 +9,127 @@ import (
 
 // Functions
 
-// expungeFolder generates an EXPUNGE command and removes
-// all messages with a \Deleted flag from supplied folder.
-func Generator(conf *config.Config, jobs chan Session, users []config.User) {
+// sessionOptions returns the options sessions are generated
+// with for a server whose support of an extension is
+// reported by supports and that separates hierarchy
+// levels with delimiter.
+func sessionOptions(conf *config.Config, supports func(string) bool, delimiter string) sessions.Options {
+
+	return sessions.Options{
+		MinLength: conf.Session.MinLength,
+		MaxLength: conf.Session.MaxLength,
+		Transfer:  conf.Session.Transfer,
+		Move:      supports("MOVE"),
+		Delimiter: delimiter,
+		Depth:     conf.Hierarchy.Depth,
+		Breadth:   conf.Hierarchy.Breadth,
+		Nest:      conf.Hierarchy.Nest,
+		TreeOps:   conf.Hierarchy.TreeOps,
+	}
+}
+
+// discoverOptions returns the options sessions are generated
+// with after discovering the mailbox via conn of worker id.
+// The hierarchy delimiter is only queried if folders may be
+// nested.
+func discoverOptions(conn *Conn, id int, conf *config.Config) (sessions.Options, error) {
+
+	delimiter := ""
+
+	if conf.Hierarchy.Depth > 1 {
+
+		var err error
+		delimiter, err = conn.delimiter(fmt.Sprintf("%dD0", id))
+		if err != nil {
+			return sessions.Options{}, err
+		}
+	}
+
+	return sessionOptions(conf, conn.Supports, delimiter), nil
+}
+
+// Generator creates the configured number of sessions
+// for users chosen by selector and hands them to workers.
+// If multiple devices per user are configured, sessions
+// are generated in user groups of that many sessions.
+// Every session is sent to the endpoint chosen by
+// endpoints. Extensions such as MOVE are used if listed
+// in the server's capabilities, folders are nested with
+// the server's hierarchy delimiter.
+func Generator(conf *config.Config, jobs chan Session, selector *UserSelector, endpoints *EndpointSelector, capabilities []string, delimiter string) {
+
+	devices := conf.Session.Devices
+
+	advertised := make(map[string]bool)
+	for _, capability := range capabilities {
+		advertised[capability] = true
+	}
+
+	opts := sessionOptions(conf, func(name string) bool {
+		return advertised[name]
+	}, delimiter)
 
 	// Assign jobs sessions.
-	for j := 1; j <= conf.Settings.Sessions; j++ {
+	for j := 1; j <= conf.Settings.Sessions; j += devices {
 
-		// Randomly choose a user.
-		i := rand.Intn(len(users))
+		// Choose a user according to the selection strategy.
+		user := selector.Acquire()
 
-		// Hand over the job to the worker.
-		jobs <- Session{
-			User:     users[i].Username,
-			Password: users[i].Password,
-			ID:       j,
-			Commands: sessions.GenerateSession(conf.Session.MinLength, conf.Session.MaxLength),
+		// The last group may be smaller in order to
+		// create exactly the configured sessions.
+		n := devices
+		if (j + n - 1) > conf.Settings.Sessions {
+			n = conf.Settings.Sessions - j + 1
+		}
+
+		if n > 1 {
+
+			group := newUserGroup(n)
+
+			// When discovering the mailbox, commands are
+			// generated by the first device to log in.
+			if !conf.Session.Discover {
+				group.commands, group.model = sessions.GenerateSessions(nil, n, opts)
+			}
+
+			// Hand over the sessions of all devices
+			// of the group to the workers.
+			for d := 0; d < n; d++ {
+
+				jobs <- Session{
+					User:      user.Username,
+					Password:  user.Password,
+					Mechanism: user.Mechanism,
+					ID:        j + d,
+					Group:     j,
+					Device:    d + 1,
+					Endpoint:  endpoints.Pick(user.Username),
+					group:     group,
+				}
+			}
+
+			continue
 		}
+
+		job := Session{
+			User:      user.Username,
+			Password:  user.Password,
+			Mechanism: user.Mechanism,
+			ID:        j,
+			Endpoint:  endpoints.Pick(user.Username),
+		}
+
+		// When discovering the mailbox, commands are
+		// generated by the worker after logging in.
+		if !conf.Session.Discover {
+
+			commands, model := sessions.GenerateSessions(nil, 1, opts)
+			job.Commands = commands[0]
+			job.model = model
+		}
+
+		// Hand over the job to the worker.
+		jobs <- job
 	}
 
 	// Close jobs channel to stop all worker routines.
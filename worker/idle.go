@@ -0,0 +1,473 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/golang/glog"
+)
+
+// Variables
+
+// existsResponse and expungeResponse extract the message
+// number of untagged EXISTS and EXPUNGE responses.
+var (
+	existsResponse  = regexp.MustCompile(`^\* (\d+) EXISTS`)
+	expungeResponse = regexp.MustCompile(`^\* (\d+) EXPUNGE`)
+)
+
+// Structs
+
+// IdleWatcher holds a population of connections that wait
+// in IDLE for new messages while sessions run. Sessions
+// report every completed APPEND, COPY and MOVE to it, and
+// the time until an idle connection of the same mailbox
+// receives EXISTS is recorded as notification latency.
+type IdleWatcher struct {
+	lock    sync.Mutex
+	clients map[string][]*idleClient
+	stop    chan struct{}
+	running sync.WaitGroup
+	results sync.Mutex
+	summary IdleSummary
+	entries []string
+}
+
+// IdleSummary counts the idle connections of a run.
+// Missed is the number of appends that no idle
+// connection of the mailbox was notified of.
+type IdleSummary struct {
+	Connections int
+	Established int
+	Failed      int
+	Dropped     int
+	Missed      int
+}
+
+// idleClient is one idle connection. exists is the number
+// of messages in the mailbox, appends holds the completion
+// times of appends not yet notified and notices the times
+// of notifications not yet matched to an append.
+type idleClient struct {
+	lock    sync.Mutex
+	exists  int
+	appends []int64
+	notices []int64
+}
+
+// Functions
+
+// NewIdleWatcher creates an IdleWatcher without connections.
+func NewIdleWatcher() *IdleWatcher {
+
+	return &IdleWatcher{
+		clients: make(map[string][]*idleClient),
+		stop:    make(chan struct{}),
+	}
+}
+
+// idleKey identifies the idle connections
+// waiting on mailbox of user.
+func idleKey(user string, mailbox string) string {
+	return fmt.Sprintf("%s:%s", user, mailbox)
+}
+
+// idle sends IDLE with the supplied tag, calls idling once
+// the server accepted it and passes all untagged responses
+// to handle until stop is closed or renew has passed. It
+// then ends IDLE via DONE and reports whether stop was
+// the reason.
+func (c *Conn) idle(tag string, stop <-chan struct{}, renew time.Duration, idling func(), handle func(string)) (bool, error) {
+
+	c.status = ""
+
+	_, err := fmt.Fprintf(c.c, "%s IDLE\r\n", tag)
+	if err != nil {
+		return false, fmt.Errorf("error during sending IDLE: %v", err)
+	}
+
+	for {
+
+		answer, err := c.readResponse()
+		if err != nil {
+			return false, fmt.Errorf("error during receiving response to IDLE: %v", err)
+		}
+
+		if strings.HasPrefix(answer, "+") {
+			break
+		}
+
+		if strings.HasPrefix(answer, (tag + " ")) {
+			c.status = responseStatus(answer)
+			return false, fmt.Errorf("server responded unexpectedly to IDLE: %s", strings.TrimSpace(answer))
+		}
+
+		handle(answer)
+	}
+
+	idling()
+
+	finished := make(chan struct{})
+	defer close(finished)
+
+	stopped := make(chan bool, 1)
+
+	go func() {
+
+		timer := time.NewTimer(renew)
+		defer timer.Stop()
+
+		select {
+		case <-stop:
+			stopped <- true
+		case <-timer.C:
+			stopped <- false
+		case <-finished:
+			return
+		}
+
+		fmt.Fprintf(c.c, "DONE\r\n")
+	}()
+
+	for {
+
+		answer, err := c.readResponse()
+		if err != nil {
+			return false, fmt.Errorf("error during receiving response to IDLE: %v", err)
+		}
+
+		if strings.HasPrefix(answer, "* BYE") {
+			return false, fmt.Errorf("server closed the connection: %s", strings.TrimSpace(answer))
+		}
+
+		if !strings.HasPrefix(answer, (tag + " ")) {
+			handle(answer)
+			continue
+		}
+
+		c.status = responseStatus(answer)
+
+		if c.status != "OK" {
+			return false, fmt.Errorf("server responded unexpectedly to IDLE: %s", strings.TrimSpace(answer))
+		}
+
+		// The server may end IDLE by itself.
+		select {
+		case reason := <-stopped:
+			return reason, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+// handle updates the number of messages of the client with
+// an untagged response and matches every new message to
+// the earliest pending append. Latencies are recorded in w.
+func (w *IdleWatcher) handle(client *idleClient, answer string) {
+
+	received := time.Now().UnixNano()
+
+	var matched [][2]int64
+
+	client.lock.Lock()
+
+	if m := existsResponse.FindStringSubmatch(answer); m != nil {
+
+		exists, _ := strconv.Atoi(m[1])
+
+		// Without an increase, expunges have not
+		// been reported and one message is new.
+		if exists <= client.exists {
+			client.exists = exists - 1
+		}
+
+		for ; client.exists < exists; client.exists++ {
+
+			if len(client.appends) == 0 {
+				client.notices = append(client.notices, received)
+				continue
+			}
+
+			matched = append(matched, [2]int64{client.appends[0], received})
+			client.appends = client.appends[1:]
+		}
+
+		client.exists = exists
+	}
+
+	if expungeResponse.MatchString(answer) && (client.exists > 0) {
+		client.exists--
+	}
+
+	client.lock.Unlock()
+
+	for _, m := range matched {
+		w.record(m[0], m[1])
+	}
+}
+
+// record stores the latency of a notification received at
+// received for an append that completed at appended. The
+// notification may arrive after the command was sent but
+// before the session saw it complete, which counts as no
+// latency.
+func (w *IdleWatcher) record(appended int64, received int64) {
+
+	latency := received - appended
+	if latency < 0 {
+		latency = 0
+	}
+
+	metrics.NotificationLatency.Observe(float64(latency) / float64(time.Second))
+
+	w.results.Lock()
+	w.entries = append(w.entries, fmt.Sprintf("[%d,\"EXISTS\",%d]", appended, latency))
+	w.results.Unlock()
+}
+
+// appended reports a completed APPEND, COPY or MOVE of a
+// message to mailbox of user, sent at sent, to all idle
+// connections waiting on that mailbox. Notifications that
+// arrived before the command was sent cannot stem from it,
+// e.g. those of messages added by other clients, and are
+// dropped.
+func (w *IdleWatcher) appended(user string, mailbox string, sent int64) {
+
+	completed := time.Now().UnixNano()
+
+	w.lock.Lock()
+	clients := w.clients[idleKey(user, mailbox)]
+	w.lock.Unlock()
+
+	for _, client := range clients {
+
+		var received int64 = -1
+
+		client.lock.Lock()
+
+		for (len(client.notices) > 0) && (client.notices[0] < sent) {
+			client.notices = client.notices[1:]
+		}
+
+		if len(client.notices) > 0 {
+			received = client.notices[0]
+			client.notices = client.notices[1:]
+		} else {
+			client.appends = append(client.appends, completed)
+		}
+
+		client.lock.Unlock()
+
+		if received >= 0 {
+			w.record(completed, received)
+		}
+	}
+}
+
+// register adds client as idle connection on mailbox of user.
+func (w *IdleWatcher) register(user string, mailbox string, client *idleClient) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	key := idleKey(user, mailbox)
+	w.clients[key] = append(w.clients[key], client)
+}
+
+// unregister removes client from the idle connections
+// of mailbox of user and counts its missed appends.
+func (w *IdleWatcher) unregister(user string, mailbox string, client *idleClient) {
+
+	w.lock.Lock()
+
+	key := idleKey(user, mailbox)
+	clients := w.clients[key]
+
+	for i := range clients {
+
+		if clients[i] == client {
+			w.clients[key] = append(clients[:i:i], clients[(i+1):]...)
+			break
+		}
+	}
+
+	w.lock.Unlock()
+
+	client.lock.Lock()
+	missed := len(client.appends)
+	client.lock.Unlock()
+
+	w.results.Lock()
+	w.summary.Missed += missed
+	w.results.Unlock()
+}
+
+// count applies update to the summary.
+func (w *IdleWatcher) count(update func(*IdleSummary)) {
+
+	w.results.Lock()
+	defer w.results.Unlock()
+
+	update(&w.summary)
+}
+
+// connectIdle logs in user at addr with the connection of the
+// supplied id and selects folder. It returns the connection,
+// the name of the mailbox on the server and its messages.
+func connectIdle(id int, conf *config.Config, addr string, user config.User, folder string) (*Conn, string, int, error) {
+
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("unable to connect to remote server %s: %v", addr, err)
+	}
+
+	err = conn.login(user, conf.Auth, id)
+	if err != nil {
+		conn.close()
+		return nil, "", 0, err
+	}
+
+	if !conn.Supports("IDLE") {
+		conn.logout(id)
+		return nil, "", 0, fmt.Errorf("server does not advertise IDLE")
+	}
+
+	delimiter, err := conn.delimiter(fmt.Sprintf("%dI1", id))
+	if err != nil {
+		conn.logout(id)
+		return nil, "", 0, err
+	}
+
+	mailbox := serverName(folder, delimiter)
+
+	untagged, err := conn.sendCommand(fmt.Sprintf("%dI2", id), fmt.Sprintf("SELECT %s", quoteString(mailbox)))
+	if err != nil {
+		conn.logout(id)
+		return nil, "", 0, err
+	}
+
+	exists := 0
+	for _, answer := range untagged {
+
+		if m := existsResponse.FindStringSubmatch(answer); m != nil {
+			exists, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	return conn, mailbox, exists, nil
+}
+
+// run keeps the idle connection of the supplied id open for
+// user on folder until the watcher is stopped. ready is done
+// once the connection waits in IDLE or failed to do so.
+func (w *IdleWatcher) run(id int, conf *config.Config, addr string, user config.User, folder string, ready *sync.WaitGroup) {
+
+	defer w.running.Done()
+
+	conn, mailbox, exists, err := connectIdle(id, conf, addr, user, folder)
+	if err != nil {
+
+		glog.Warningf("Idle connection %d of user %s failed: %v", id, user.Username, err)
+		w.count(func(s *IdleSummary) { s.Failed++ })
+		ready.Done()
+
+		return
+	}
+
+	client := &idleClient{
+		exists: exists,
+	}
+
+	w.register(user.Username, mailbox, client)
+	defer w.unregister(user.Username, mailbox, client)
+
+	w.count(func(s *IdleSummary) { s.Established++ })
+	metrics.IdleConnections.Inc()
+	defer metrics.IdleConnections.Dec()
+
+	renew := time.Duration(conf.Idle.Renew) * time.Second
+	handle := func(answer string) {
+		w.handle(client, answer)
+	}
+
+	readyOnce := sync.Once{}
+	idling := func() {
+		readyOnce.Do(ready.Done)
+	}
+
+	for tag := 3; ; tag++ {
+
+		stopped, err := conn.idle(fmt.Sprintf("%dI%d", id, tag), w.stop, renew, idling, handle)
+
+		// Connections rejecting IDLE right
+		// away are not waited for either.
+		idling()
+
+		if err != nil {
+
+			glog.Warningf("Idle connection %d of user %s dropped: %v", id, user.Username, err)
+			w.count(func(s *IdleSummary) { s.Dropped++ })
+			metrics.IdleDropped.Inc()
+			conn.close()
+
+			return
+		}
+
+		if stopped {
+			conn.logout(id)
+			return
+		}
+	}
+}
+
+// Start opens the configured number of idle connections,
+// spread over users and the configured folders, and returns
+// once all of them wait in IDLE or failed to do so.
+func (w *IdleWatcher) Start(conf *config.Config, users []config.User) {
+
+	targets := conf.Server.Targets()
+
+	w.summary.Connections = conf.Idle.Connections
+
+	var ready sync.WaitGroup
+
+	for i := 0; i < conf.Idle.Connections; i++ {
+
+		user := users[(i % len(users))]
+		folder := conf.Idle.Folders[((i / len(users)) % len(conf.Idle.Folders))]
+		addr := targets[(i % len(targets))].Addr
+
+		ready.Add(1)
+		w.running.Add(1)
+
+		go w.run((i + 1), conf, addr, user, folder, &ready)
+	}
+
+	ready.Wait()
+
+	w.results.Lock()
+	established := w.summary.Established
+	w.results.Unlock()
+
+	glog.Infof("Established %d of %d idle connections", established, conf.Idle.Connections)
+}
+
+// Stop ends IDLE on all connections and logs them out.
+// It returns the summary and the recorded notifications.
+func (w *IdleWatcher) Stop() (IdleSummary, []string) {
+
+	close(w.stop)
+	w.running.Wait()
+
+	w.results.Lock()
+	defer w.results.Unlock()
+
+	return w.summary, w.entries
+}
@@ -0,0 +1,504 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pluto/benchmark/sessions"
+)
+
+// Variables
+
+// literalSuffix matches a response line that announces
+// a literal of the captured number of bytes to follow.
+var literalSuffix = regexp.MustCompile(`\{(\d+)\+?\}\r\n$`)
+
+// messagesStatus extracts the number of messages
+// from an untagged STATUS response.
+var messagesStatus = regexp.MustCompile(`MESSAGES (\d+)`)
+
+// fetchFlags extracts sequence number and flags
+// from an untagged FETCH response.
+var fetchFlags = regexp.MustCompile(`^\* (\d+) FETCH \(.*FLAGS \(([^)]*)\)`)
+
+// fetchUID extracts sequence number and UID
+// from an untagged FETCH response.
+var fetchUID = regexp.MustCompile(`^\* (\d+) FETCH \(.*UID (\d+)`)
+
+// embeddedLiteral matches a literal that quoteString
+// embedded in a command line, capturing its size.
+var embeddedLiteral = regexp.MustCompile(`\{(\d+)\}\r\n`)
+
+// Functions
+
+// quoteString returns s, e.g. a mailbox name or password,
+// as IMAP astring, i.e. as quoted string if it contains
+// characters not allowed in atoms. Control characters,
+// including CR and LF, and 8-bit characters cannot be
+// quoted, so such strings are embedded as literal that
+// writeCommand sends as the server requires.
+func quoteString(s string) string {
+
+	for i := 0; i < len(s); i++ {
+
+		if (s[i] < 0x20) || (s[i] >= 0x7f) {
+			return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+		}
+	}
+
+	if (s != "") && !strings.ContainsAny(s, " \"\\(){%*]") {
+		return s
+	}
+
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+	return fmt.Sprintf("\"%s\"", replacer.Replace(s))
+}
+
+// writeCommand sends line followed by CRLF.
+func (c *Conn) writeCommand(line string) error {
+
+	err := c.writeLiterals(line)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.c, "\r\n")
+	return err
+}
+
+// writeLiterals sends s, a command line or the beginning
+// of one. Literals that quoteString embedded in s are sent
+// right away as non-synchronizing literals if the server
+// advertised LITERAL+, otherwise only after its continuation
+// request.
+func (c *Conn) writeLiterals(s string) error {
+
+	for {
+
+		m := embeddedLiteral.FindStringSubmatchIndex(s)
+		size := 0
+		if m != nil {
+			size, _ = strconv.Atoi(s[m[2]:m[3]])
+		}
+
+		if (m == nil) || ((m[1] + size) > len(s)) {
+			_, err := fmt.Fprintf(c.c, "%s", s)
+			return err
+		}
+
+		head, literal := s[:m[0]], s[m[1]:(m[1]+size)]
+		s = s[(m[1] + size):]
+
+		if c.capabilities["LITERAL+"] {
+
+			_, err := fmt.Fprintf(c.c, "%s{%d+}\r\n%s", head, size, literal)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		_, err := fmt.Fprintf(c.c, "%s{%d}\r\n", head, size)
+		if err != nil {
+			return err
+		}
+
+		// Wait for the continuation request,
+		// skipping untagged responses.
+		answer, err := c.r.ReadString('\n')
+		for (err == nil) && strings.HasPrefix(answer, "* ") {
+			answer, err = c.r.ReadString('\n')
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(answer, "+") {
+			c.status = responseStatus(answer)
+			return fmt.Errorf("did not receive continuation for literal: %s", strings.TrimSpace(answer))
+		}
+
+		_, err = fmt.Fprintf(c.c, "%s", literal)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// synchronizing reports whether line contains a literal
+// that writeCommand only sends after a continuation
+// request of the server.
+func (c *Conn) synchronizing(line string) bool {
+	return !c.capabilities["LITERAL+"] && embeddedLiteral.MatchString(line)
+}
+
+// readResponse reads one response line from the server.
+// Literals announced by the line are read and appended
+// to it, followed by the remainder of the line.
+func (c *Conn) readResponse() (string, error) {
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	for {
+
+		m := literalSuffix.FindStringSubmatch(line)
+		if m == nil {
+			return line, nil
+		}
+
+		size, err := strconv.Atoi(m[1])
+		if err != nil {
+			return "", err
+		}
+
+		literal := make([]byte, size)
+
+		_, err = io.ReadFull(c.r, literal)
+		if err != nil {
+			return "", err
+		}
+
+		rest, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = line + string(literal) + rest
+	}
+}
+
+// sendCommand sends command with the supplied tag and collects
+// all untagged responses until the tagged completion response.
+// It fails if the command does not complete with OK.
+func (c *Conn) sendCommand(tag string, command string) ([]string, error) {
+
+	name := strings.Split(command, " ")[0]
+
+	c.status = ""
+
+	// Start time taken here.
+	timeStart := time.Now().UnixNano()
+
+	err := c.writeCommand(fmt.Sprintf("%s %s", tag, command))
+	if err != nil {
+		return nil, fmt.Errorf("error during sending %s: %v", name, err)
+	}
+
+	return c.awaitCompletion(tag, name, timeStart)
+}
+
+// awaitCompletion collects all untagged responses until the
+// tagged completion response to command name sent at timeStart.
+// It fails if the command does not complete with OK.
+func (c *Conn) awaitCompletion(tag string, name string, timeStart int64) ([]string, error) {
+
+	var untagged []string
+
+	for {
+
+		answer, err := c.readResponse()
+		if err != nil {
+			return nil, fmt.Errorf("error during receiving response to %s: %v", name, err)
+		}
+
+		if !strings.HasPrefix(answer, (tag + " ")) {
+
+			if capabilities, found := parseCapabilities(answer); found {
+				c.capabilities = capabilities
+			}
+
+			untagged = append(untagged, answer)
+			continue
+		}
+
+		observe(name, answer, (time.Now().UnixNano() - timeStart))
+		c.status = responseStatus(answer)
+
+		if responseStatus(answer) != "OK" {
+			return untagged, fmt.Errorf("server responded unexpectedly to %s: %s", name, strings.TrimSpace(answer))
+		}
+
+		return untagged, nil
+	}
+}
+
+// unquote decodes the IMAP quoted string at the beginning
+// of s, in which only '\' and '"' are escaped by a
+// backslash, and returns it along with the rest of s.
+func unquote(s string) (string, string, error) {
+
+	if !strings.HasPrefix(s, "\"") {
+		return "", "", fmt.Errorf("expected quoted string but found: %s", s)
+	}
+
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+
+		switch s[i] {
+		case '"':
+			return b.String(), s[(i + 1):], nil
+		case '\\':
+
+			i++
+			if (i == len(s)) || ((s[i] != '\\') && (s[i] != '"')) {
+				return "", "", fmt.Errorf("invalid escape in quoted string: %s", s)
+			}
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return "", "", fmt.Errorf("unterminated quoted string: %s", s)
+}
+
+// parseList extracts the attributes, the hierarchy delimiter
+// and the mailbox name from an untagged LIST response. The
+// delimiter is empty if the server does not use hierarchies.
+// Extended data following the name is ignored.
+func parseList(line string) (string, string, string, error) {
+
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if !strings.HasPrefix(line, "* LIST (") {
+		return "", "", "", fmt.Errorf("not a LIST response: %s", line)
+	}
+
+	end := strings.Index(line, ")")
+	if end < 0 {
+		return "", "", "", fmt.Errorf("malformed LIST response: %s", line)
+	}
+
+	attributes := line[len("* LIST ("):end]
+
+	// The hierarchy delimiter is either NIL
+	// or a quoted, possibly escaped character.
+	rest := strings.TrimSpace(line[(end + 1):])
+	delimiter := ""
+
+	if strings.HasPrefix(rest, "NIL") {
+		rest = rest[3:]
+	} else {
+
+		var err error
+		delimiter, rest, err = unquote(rest)
+		if err != nil {
+			return "", "", "", fmt.Errorf("malformed delimiter in LIST response: %v", err)
+		}
+	}
+
+	rest = strings.TrimSpace(rest)
+
+	// The name is a literal, a quoted string or an atom.
+	switch {
+	case strings.HasPrefix(rest, "{"):
+
+		start := strings.Index(rest, "}\r\n")
+		if start < 0 {
+			return "", "", "", fmt.Errorf("malformed LIST response: %s", line)
+		}
+
+		size, err := strconv.Atoi(rest[1:start])
+		if (err != nil) || (size > len(rest[(start+3):])) {
+			return "", "", "", fmt.Errorf("invalid literal size in LIST response: %s", line)
+		}
+
+		return attributes, delimiter, rest[(start + 3):(start + 3 + size)], nil
+
+	case strings.HasPrefix(rest, "\""):
+
+		name, _, err := unquote(rest)
+		if err != nil {
+			return "", "", "", fmt.Errorf("malformed mailbox name in LIST response: %v", err)
+		}
+
+		return attributes, delimiter, name, nil
+
+	case rest == "":
+		return "", "", "", fmt.Errorf("missing mailbox name in LIST response: %s", line)
+	}
+
+	return attributes, delimiter, strings.Fields(rest)[0], nil
+}
+
+// delimiter queries the hierarchy delimiter of
+// the server via LIST with an empty mailbox name.
+func (c *Conn) delimiter(tag string) (string, error) {
+
+	lists, err := c.sendCommand(tag, "LIST \"\" \"\"")
+	if err != nil {
+		return "", err
+	}
+
+	for _, list := range lists {
+
+		_, delimiter, _, err := parseList(list)
+		if err == nil {
+			return delimiter, nil
+		}
+	}
+
+	return "", fmt.Errorf("server did not answer LIST for the hierarchy delimiter")
+}
+
+// list returns the names of all mailboxes of the logged in
+// user via LIST and whether they can be selected. INBOX is
+// always reported in upper case.
+func (c *Conn) list(tag string) ([]string, []bool, error) {
+
+	lists, err := c.sendCommand(tag, "LIST \"\" \"*\"")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	var selectable []bool
+
+	for _, list := range lists {
+
+		attributes, _, name, err := parseList(list)
+		if err != nil {
+			continue
+		}
+
+		if strings.ToUpper(name) == "INBOX" {
+			name = "INBOX"
+		}
+
+		attributes = strings.ToLower(attributes)
+
+		names = append(names, name)
+		selectable = append(selectable, !strings.Contains(attributes, "\\noselect") && !strings.Contains(attributes, "\\nonexistent"))
+	}
+
+	return names, selectable, nil
+}
+
+// countMessages returns the number of messages
+// in mailbox name as reported by STATUS.
+func (c *Conn) countMessages(tag string, name string) (int, error) {
+
+	statuses, err := c.sendCommand(tag, fmt.Sprintf("STATUS %s (MESSAGES)", quoteString(name)))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, status := range statuses {
+
+		if m := messagesStatus.FindStringSubmatch(status); m != nil {
+			return strconv.Atoi(m[1])
+		}
+	}
+
+	return 0, fmt.Errorf("server did not report the number of messages in %s", name)
+}
+
+// uid returns the UID of message seq in the selected folder.
+func (c *Conn) uid(tag string, seq string) (string, error) {
+
+	fetches, err := c.sendCommand(tag, fmt.Sprintf("FETCH %s (UID)", seq))
+	if err != nil {
+		return "", err
+	}
+
+	for _, fetch := range fetches {
+
+		m := fetchUID.FindStringSubmatch(fetch)
+		if (m != nil) && (m[1] == seq) {
+			return m[2], nil
+		}
+	}
+
+	return "", fmt.Errorf("server did not report the UID of message %s", seq)
+}
+
+// discover determines the folders present in the mailbox of
+// the logged in user via LIST and STATUS and fetches the flags
+// of all messages in non-empty folders via EXAMINE and FETCH.
+func (c *Conn) discover(id int) ([]sessions.Folder, error) {
+
+	tag := 0
+	nextTag := func() string {
+		tag++
+		return fmt.Sprintf("%dD%d", id, tag)
+	}
+
+	names, selectable, err := c.list(nextTag())
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []sessions.Folder
+
+	for i, name := range names {
+
+		// Skip entries that cannot be selected.
+		if !selectable[i] {
+			continue
+		}
+
+		folder := sessions.Folder{
+			FolderName: name,
+		}
+
+		numMessages, err := c.countMessages(nextTag(), name)
+		if err != nil {
+			return nil, err
+		}
+
+		if numMessages > 0 {
+
+			_, err := c.sendCommand(nextTag(), fmt.Sprintf("EXAMINE %s", quoteString(name)))
+			if err != nil {
+				return nil, err
+			}
+
+			fetches, err := c.sendCommand(nextTag(), "FETCH 1:* (FLAGS)")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, fetch := range fetches {
+
+				m := fetchFlags.FindStringSubmatch(fetch)
+				if m == nil {
+					continue
+				}
+
+				seq, _ := strconv.Atoi(m[1])
+				for len(folder.Messages) < seq {
+					folder.Messages = append(folder.Messages, sessions.Message{})
+				}
+
+				var flags []string
+				for _, flag := range strings.Fields(m[2]) {
+					if flag != "\\Recent" {
+						flags = append(flags, flag)
+					}
+				}
+
+				folder.Messages[(seq - 1)].Flags = flags
+			}
+
+			// Leave the examined folder again.
+			_, err = c.sendCommand(nextTag(), "CLOSE")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		folders = append(folders, folder)
+	}
+
+	return folders, nil
+}
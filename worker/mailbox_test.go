@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"testing"
+)
+
+// Functions
+
+func TestQuoteString(t *testing.T) {
+
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"atom", "INBOX", "INBOX"},
+		{"atom with delimiter", "Work/Projects", "Work/Projects"},
+		{"empty", "", "\"\""},
+		{"space", "Sent Items", "\"Sent Items\""},
+		{"quote", "say \"hi\"", "\"say \\\"hi\\\"\""},
+		{"backslash", "a\\b", "\"a\\\\b\""},
+		{"wildcards", "a*b%", "\"a*b%\""},
+		{"parentheses", "(a)", "\"(a)\""},
+		{"tab", "a\tb", "{3}\r\na\tb"},
+		{"line break", "a\r\nb", "{4}\r\na\r\nb"},
+		{"delete", "a\x7f", "{2}\r\na\x7f"},
+		{"8-bit", "Entwürfe", "{9}\r\nEntwürfe"},
+		{"8-bit with space", "Gelöschte Elemente", "{19}\r\nGelöschte Elemente"},
+	}
+
+	for _, test := range tests {
+
+		got := quoteString(test.s)
+		if got != test.want {
+			t.Errorf("%s: quoteString(%q) = %q, want %q", test.name, test.s, got, test.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		line       string
+		attributes string
+		delimiter  string
+		mailbox    string
+		fail       bool
+	}{
+		{
+			name:       "atom",
+			line:       "* LIST (\\HasNoChildren) \"/\" INBOX\r\n",
+			attributes: "\\HasNoChildren",
+			delimiter:  "/",
+			mailbox:    "INBOX",
+		},
+		{
+			name:      "no hierarchy",
+			line:      "* LIST () NIL Archive\r\n",
+			delimiter: "",
+			mailbox:   "Archive",
+		},
+		{
+			name:      "escaped delimiter",
+			line:      "* LIST () \"\\\\\" Work\\Projects\r\n",
+			delimiter: "\\",
+			mailbox:   "Work\\Projects",
+		},
+		{
+			name:      "quoted",
+			line:      "* LIST () \".\" \"Sent Items\"\r\n",
+			delimiter: ".",
+			mailbox:   "Sent Items",
+		},
+		{
+			name:      "quoted with escapes",
+			line:      "* LIST () \"/\" \"say \\\"hi\\\" \\\\ bye\"\r\n",
+			delimiter: "/",
+			mailbox:   "say \"hi\" \\ bye",
+		},
+		{
+			// Go escapes such as \n or \u are not part of
+			// IMAP quoted strings.
+			name: "unknown escape",
+			line: "* LIST () \"/\" \"a\\nb\"\r\n",
+			fail: true,
+		},
+		{
+			name: "unterminated quoted",
+			line: "* LIST () \"/\" \"Sent Items\r\n",
+			fail: true,
+		},
+		{
+			name:       "extended data",
+			line:       "* LIST (\\Subscribed) \"/\" \"Sent Items\" (\"CHILDINFO\" (\"SUBSCRIBED\"))\r\n",
+			attributes: "\\Subscribed",
+			delimiter:  "/",
+			mailbox:    "Sent Items",
+		},
+		{
+			name:      "literal",
+			line:      "* LIST () \"/\" {9}\r\nEntwürfe\r\n",
+			delimiter: "/",
+			mailbox:   "Entwürfe",
+		},
+		{
+			name:      "literal with extended data",
+			line:      "* LIST () \"/\" {5}\r\nA\"B\\C (\"CHILDINFO\" (\"SUBSCRIBED\"))\r\n",
+			delimiter: "/",
+			mailbox:   "A\"B\\C",
+		},
+		{
+			name: "truncated literal",
+			line: "* LIST () \"/\" {20}\r\nshort\r\n",
+			fail: true,
+		},
+		{
+			name: "missing name",
+			line: "* LIST () \"/\"\r\n",
+			fail: true,
+		},
+		{
+			name: "not a LIST response",
+			line: "* STATUS INBOX (MESSAGES 1)\r\n",
+			fail: true,
+		},
+	}
+
+	for _, test := range tests {
+
+		attributes, delimiter, mailbox, err := parseList(test.line)
+
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error for %q but got %q", test.name, test.line, mailbox)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if (attributes != test.attributes) || (delimiter != test.delimiter) || (mailbox != test.mailbox) {
+			t.Errorf("%s: parseList(%q) = (%q, %q, %q), want (%q, %q, %q)", test.name, test.line, attributes, delimiter, mailbox, test.attributes, test.delimiter, test.mailbox)
+		}
+	}
+}
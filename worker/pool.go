@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/golang/glog"
+)
+
+// Structs
+
+// connPool keeps the authenticated connections of finished
+// sessions of one worker, so that later sessions of the same
+// user at the same endpoint can skip connecting and LOGIN.
+// Sessions of other users at the endpoint may take over a
+// connection by logging in again if the server supports it.
+// At most size connections are kept, the least recently
+// used one is logged out first. Connections older than
+// lifetime are logged out instead of being reused.
+type connPool struct {
+	conns    map[string]*pooledConn
+	size     int
+	lifetime time.Duration
+}
+
+// pooledConn is a connection kept in a connPool
+// together with its endpoint, the user logged in,
+// its login and last use time.
+type pooledConn struct {
+	conn     *Conn
+	endpoint string
+	user     string
+	login    time.Time
+	used     time.Time
+}
+
+// Functions
+
+// newConnPool creates the connection pool of a worker
+// as configured. In mode "session", it keeps nothing.
+func newConnPool(conf *config.Config) *connPool {
+
+	p := &connPool{
+		conns: make(map[string]*pooledConn),
+	}
+
+	if conf.Connection.Mode != "session" {
+		p.size = conf.Connection.PoolSize
+		p.lifetime = time.Duration(conf.Connection.Lifetime) * time.Second
+	}
+
+	return p
+}
+
+// poolKey identifies the connections of user at endpoint.
+func poolKey(endpoint string, user string) string {
+	return fmt.Sprintf("%s/%s", endpoint, user)
+}
+
+// expired reports whether pc exceeded the lifetime.
+func (p *connPool) expired(pc *pooledConn) bool {
+	return (p.lifetime > 0) && (time.Since(pc.login) > p.lifetime)
+}
+
+// get removes a connection to endpoint from the pool and
+// returns it, or nil if there is none to reuse. The connection
+// of user is preferred. Otherwise, the least recently used
+// connection of another user is taken if the server supports
+// UNAUTHENTICATE. switched reports this case, the caller then
+// has to log in user on the connection via relogin.
+func (p *connPool) get(endpoint string, user string, id int) (*Conn, bool) {
+
+	key := poolKey(endpoint, user)
+
+	if pc, found := p.conns[key]; found {
+
+		delete(p.conns, key)
+
+		if !p.expired(pc) {
+			return pc.conn, false
+		}
+
+		pc.conn.logout(id)
+	}
+
+	var others []string
+	for key, pc := range p.conns {
+
+		if (pc.endpoint == endpoint) && !p.expired(pc) {
+			others = append(others, key)
+		}
+	}
+
+	sort.Slice(others, func(i, j int) bool {
+		return p.conns[others[i]].used.Before(p.conns[others[j]].used)
+	})
+
+	for _, key := range others {
+
+		pc := p.conns[key]
+
+		if pc.conn.Supports("UNAUTHENTICATE") {
+			delete(p.conns, key)
+			return pc.conn, true
+		}
+	}
+
+	return nil, false
+}
+
+// put hands the connection of a finished session of user at
+// endpoint back to the pool. If the pool is full, the least
+// recently used connection is logged out.
+func (p *connPool) put(endpoint string, user string, conn *Conn, id int) {
+
+	if p.size == 0 {
+		conn.logout(id)
+		return
+	}
+
+	key := poolKey(endpoint, user)
+
+	// A connection the user logged in on meanwhile
+	// is replaced by the more recently used one.
+	if pc, found := p.conns[key]; found {
+		pc.conn.logout(id)
+		delete(p.conns, key)
+	}
+
+	if len(p.conns) >= p.size {
+
+		oldest := ""
+		for key, pc := range p.conns {
+			if (oldest == "") || pc.used.Before(p.conns[oldest].used) {
+				oldest = key
+			}
+		}
+
+		p.conns[oldest].conn.logout(id)
+		delete(p.conns, oldest)
+	}
+
+	p.conns[key] = &pooledConn{
+		conn:     conn,
+		endpoint: endpoint,
+		user:     user,
+		login:    conn.loginTime,
+		used:     time.Now(),
+	}
+}
+
+// keepAlive sends NOOP on all idle connections so the
+// server does not log them out. Expired connections and
+// those that fail are removed from the pool.
+func (p *connPool) keepAlive(id int) {
+
+	for key, pc := range p.conns {
+
+		if p.expired(pc) {
+			pc.conn.logout(id)
+			delete(p.conns, key)
+			continue
+		}
+
+		_, err := pc.conn.sendCommand(fmt.Sprintf("%dN", id), "NOOP")
+		if err != nil {
+			glog.Warningf("Dropping idle connection %s: %v", key, err)
+			pc.conn.close()
+			delete(p.conns, key)
+		}
+	}
+}
+
+// close logs out all connections of the pool.
+func (p *connPool) close(id int) {
+
+	for key, pc := range p.conns {
+		pc.conn.logout(id)
+		delete(p.conns, key)
+	}
+}
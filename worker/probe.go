@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+
+	"crypto/tls"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Functions
+
+// Probe connects once to the server at addr and returns
+// the resolved remote address as well as the parameters
+// negotiated in the TLS handshake.
+func Probe(addr string) (net.Addr, tls.ConnectionState, error) {
+
+	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	defer tlsConn.Close()
+
+	return tlsConn.RemoteAddr(), tlsConn.ConnectionState(), nil
+}
+
+// ProbeLogin logs in user at the configured server and
+// returns the capabilities it advertises after login as
+// well as its hierarchy delimiter.
+func ProbeLogin(conf *config.Config, user config.User) ([]string, string, error) {
+
+	conn, err := dial(conf.Server.Addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to remote server %s: %v", conf.Server.Addr, err)
+	}
+
+	err = conn.login(user, conf.Auth, 0)
+	if err != nil {
+		conn.close()
+		return nil, "", err
+	}
+	defer conn.logout(0)
+
+	capabilities, err := conn.Capabilities()
+	if err != nil {
+		return nil, "", err
+	}
+
+	delimiter, err := conn.delimiter("L")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return capabilities, delimiter, nil
+}
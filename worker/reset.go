@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/golang/glog"
+)
+
+// Variables
+
+// benchmarkFolder matches the names of folders created
+// by sessions, i.e. a worker or group prefix followed
+// by a generated name and the generated names of any
+// nested levels.
+var benchmarkFolder = regexp.MustCompile(`^\d+[XG][a-z0-9]{8}([^a-z0-9][a-z0-9]{8})*$`)
+
+// Structs
+
+// ResetResult lists the folders deleted from the mailbox
+// of a user and the number of messages removed with them.
+// In a dry run, nothing has actually been deleted.
+type ResetResult struct {
+	User     string
+	Folders  []string
+	Messages int
+	Err      error
+}
+
+// Functions
+
+// depth returns the hierarchy level of name.
+func depth(name string, delimiter string) int {
+
+	if delimiter == "" {
+		return 0
+	}
+
+	return strings.Count(name, delimiter)
+}
+
+// resetMailbox deletes all folders created by sessions from
+// the mailbox of the logged in user. If seeded is set, the
+// folders of the [seed] section are deleted as well and
+// INBOX is emptied. If dryRun is set, nothing is deleted.
+func (c *Conn) resetMailbox(id int, conf *config.Config, seeded bool, dryRun bool) (ResetResult, error) {
+
+	result := ResetResult{}
+
+	tag := 0
+	nextTag := func() string {
+		tag++
+		return fmt.Sprintf("%dR%d", id, tag)
+	}
+
+	delimiter, err := c.delimiter(nextTag())
+	if err != nil {
+		return result, err
+	}
+
+	names, selectable, err := c.list(nextTag())
+	if err != nil {
+		return result, err
+	}
+
+	seedFolders := make(map[string]bool)
+	if seeded {
+
+		for _, folder := range conf.Seed.Folders {
+			seedFolders[serverName(folder, delimiter)] = true
+		}
+	}
+
+	emptyInbox := false
+
+	for i, name := range names {
+
+		switch {
+		case name == "INBOX":
+			emptyInbox = seeded
+			continue
+		case benchmarkFolder.MatchString(name), seedFolders[name]:
+		default:
+			continue
+		}
+
+		if selectable[i] {
+
+			numMessages, err := c.countMessages(nextTag(), name)
+			if err != nil {
+				return result, err
+			}
+
+			result.Messages += numMessages
+		}
+
+		result.Folders = append(result.Folders, name)
+	}
+
+	// Delete children before their parents.
+	sort.SliceStable(result.Folders, func(i, j int) bool {
+		return depth(result.Folders[i], delimiter) > depth(result.Folders[j], delimiter)
+	})
+
+	numInbox := 0
+	if emptyInbox {
+
+		numInbox, err = c.countMessages(nextTag(), "INBOX")
+		if err != nil {
+			return result, err
+		}
+
+		result.Messages += numInbox
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	for _, name := range result.Folders {
+
+		_, err := c.sendCommand(nextTag(), fmt.Sprintf("DELETE %s", quoteString(name)))
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if numInbox > 0 {
+
+		_, err := c.sendCommand(nextTag(), "SELECT INBOX")
+		if err != nil {
+			return result, err
+		}
+
+		_, err = c.sendCommand(nextTag(), "STORE 1:* +FLAGS.SILENT (\\Deleted)")
+		if err != nil {
+			return result, err
+		}
+
+		// CLOSE expunges all messages flagged \Deleted.
+		_, err = c.sendCommand(nextTag(), "CLOSE")
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Resetter is the routine that cleans up the mailboxes
+// of the users it receives. See resetMailbox for the
+// meaning of seeded and dryRun. The outcome for each
+// user is written to the results channel.
+func Resetter(id int, conf *config.Config, users <-chan config.User, seeded bool, dryRun bool, results chan<- ResetResult) {
+
+	for user := range users {
+
+		conn, err := dial(conf.Server.Addr)
+		if err != nil {
+
+			results <- ResetResult{
+				User: user.Username,
+				Err:  fmt.Errorf("unable to connect to remote server %s: %v", conf.Server.Addr, err),
+			}
+
+			continue
+		}
+
+		err = conn.login(user, conf.Auth, id)
+		if err != nil {
+
+			conn.close()
+
+			results <- ResetResult{
+				User: user.Username,
+				Err:  err,
+			}
+
+			continue
+		}
+
+		glog.V(2).Info("LOGIN successful, user: ", user.Username, " pw: ", conf.Output.Redact(user.Password))
+
+		result, err := conn.resetMailbox(id, conf, seeded, dryRun)
+		result.User = user.Username
+		result.Err = err
+
+		conn.logout(id)
+
+		results <- result
+	}
+}
@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/utils"
+	"github.com/golang/glog"
+)
+
+// Structs
+
+// SeedJob contains the user's credentials and the
+// folders and messages to fill the user's mailbox with.
+type SeedJob struct {
+	User      string
+	Password  string
+	Mechanism string
+	Folders   []SeedFolder
+}
+
+// SeedFolder represents a folder to seed. Its name
+// uses "/" as hierarchy delimiter.
+type SeedFolder struct {
+	Name     string
+	Messages []SeedMessage
+}
+
+// SeedMessage represents a message to append
+// including its flag list, which may be empty.
+type SeedMessage struct {
+	Flags   string
+	Literal string
+}
+
+// SeedResult reports how many messages have
+// been appended to the mailbox of a user.
+type SeedResult struct {
+	User     string
+	Messages int
+	Err      error
+}
+
+// Functions
+
+// SeedGenerator creates the seed jobs of all users as
+// described by the config and hands them to seeders.
+// Jobs are generated one after another from the global
+// random source, so a fixed seed results in the same
+// mailboxes regardless of the number of seeders.
+func SeedGenerator(conf *config.Config, users []config.User, jobs chan<- SeedJob) {
+
+	names := append([]string{"INBOX"}, conf.Seed.Folders...)
+
+	for _, user := range users {
+
+		job := SeedJob{
+			User:      user.Username,
+			Password:  user.Password,
+			Mechanism: user.Mechanism,
+		}
+
+		for _, name := range names {
+
+			folder := SeedFolder{
+				Name: name,
+			}
+
+			numMessages := rand.Intn((conf.Seed.MaxMessages-conf.Seed.MinMessages)+1) + conf.Seed.MinMessages
+
+			for m := 0; m < numMessages; m++ {
+
+				message := SeedMessage{}
+
+				if rand.Float64() < conf.Seed.SeenRatio {
+					message.Flags = "(\\Seen)"
+				}
+
+				_, message.Literal = utils.GenerateMsgLines(conf.Seed.MinLines, conf.Seed.MaxLines)
+
+				folder.Messages = append(folder.Messages, message)
+			}
+
+			job.Folders = append(job.Folders, folder)
+		}
+
+		jobs <- job
+	}
+
+	close(jobs)
+}
+
+// serverName translates name from "/" as hierarchy
+// delimiter to the delimiter used by the server.
+func serverName(name string, delimiter string) string {
+
+	if (delimiter == "") || (delimiter == "/") {
+		return name
+	}
+
+	return strings.Replace(name, "/", delimiter, -1)
+}
+
+// appendMessages uploads messages to mailbox in one APPEND
+// command, i.e. as MULTIAPPEND if more than one is supplied.
+// Every literal is sent after the server's continuation.
+func (c *Conn) appendMessages(tag string, mailbox string, messages []SeedMessage) error {
+
+	// Start time taken here.
+	timeStart := time.Now().UnixNano()
+
+	err := c.writeLiterals(fmt.Sprintf("%s APPEND %s", tag, mailbox))
+	if err != nil {
+		return fmt.Errorf("error during sending APPEND: %v", err)
+	}
+
+	for _, message := range messages {
+
+		if message.Flags != "" {
+
+			_, err = fmt.Fprintf(c.c, " %s", message.Flags)
+			if err != nil {
+				return fmt.Errorf("error during sending APPEND: %v", err)
+			}
+		}
+
+		_, err = fmt.Fprintf(c.c, " {%d}\r\n", len(message.Literal))
+		if err != nil {
+			return fmt.Errorf("error during sending APPEND: %v", err)
+		}
+
+		// Wait for the continuation request,
+		// skipping untagged responses.
+		answer, err := c.r.ReadString('\n')
+		for (err == nil) && strings.HasPrefix(answer, "* ") {
+			answer, err = c.r.ReadString('\n')
+		}
+
+		if err != nil {
+			return fmt.Errorf("error during receiving continuation for APPEND: %v", err)
+		}
+
+		if !strings.HasPrefix(answer, "+") {
+			return fmt.Errorf("did not receive continuation for APPEND to %s: %s", mailbox, strings.TrimSpace(answer))
+		}
+
+		_, err = fmt.Fprintf(c.c, "%s", message.Literal)
+		if err != nil {
+			return fmt.Errorf("sending mail message to server failed with: %v", err)
+		}
+	}
+
+	_, err = fmt.Fprintf(c.c, "\r\n")
+	if err != nil {
+		return fmt.Errorf("error during sending APPEND: %v", err)
+	}
+
+	_, err = c.awaitCompletion(tag, "APPEND", timeStart)
+
+	return err
+}
+
+// seedMailbox fills the mailbox of the logged in user with
+// the folders and messages of job. Missing folders and their
+// parents are created. Folders that already contain messages
+// only receive the messages exceeding their current number,
+// so that seeding again leads to the same state. The number
+// of appended messages is returned.
+func (c *Conn) seedMailbox(id int, conf *config.Config, job SeedJob) (int, error) {
+
+	tag := 0
+	nextTag := func() string {
+		tag++
+		return fmt.Sprintf("%dS%d", id, tag)
+	}
+
+	delimiter, err := c.delimiter(nextTag())
+	if err != nil {
+		return 0, err
+	}
+
+	folders, err := c.discover(id)
+	if err != nil {
+		return 0, err
+	}
+
+	present := make(map[string]int)
+	for _, folder := range folders {
+		present[folder.FolderName] = len(folder.Messages)
+	}
+
+	batchSize := 1
+	if conf.Seed.MultiAppend {
+		batchSize = conf.Seed.BatchSize
+	}
+
+	appended := 0
+
+	for _, folder := range job.Folders {
+
+		name := serverName(folder.Name, delimiter)
+
+		// Create the folder including all missing parents.
+		parts := strings.Split(folder.Name, "/")
+		for i := range parts {
+
+			parent := serverName(strings.Join(parts[:(i+1)], "/"), delimiter)
+			if _, ok := present[parent]; ok || (parent == "INBOX") {
+				continue
+			}
+
+			_, err := c.sendCommand(nextTag(), fmt.Sprintf("CREATE %s", quoteString(parent)))
+			if err != nil {
+				return appended, err
+			}
+
+			present[parent] = 0
+		}
+
+		messages := folder.Messages
+		if present[name] >= len(messages) {
+			continue
+		}
+
+		messages = messages[present[name]:]
+
+		for len(messages) > 0 {
+
+			n := batchSize
+			if n > len(messages) {
+				n = len(messages)
+			}
+
+			err := c.appendMessages(nextTag(), quoteString(name), messages[:n])
+			if err != nil {
+				return appended, err
+			}
+
+			appended += n
+			messages = messages[n:]
+		}
+	}
+
+	return appended, nil
+}
+
+// Seeder is the routine that fills the mailboxes of
+// the users of all jobs it receives. The outcome of
+// each job is written to the results channel.
+func Seeder(id int, conf *config.Config, jobs <-chan SeedJob, results chan<- SeedResult) {
+
+	for job := range jobs {
+
+		result := SeedResult{
+			User: job.User,
+		}
+
+		conn, err := dial(conf.Server.Addr)
+		if err != nil {
+			result.Err = fmt.Errorf("unable to connect to remote server %s: %v", conf.Server.Addr, err)
+			results <- result
+			continue
+		}
+
+		err = conn.login(config.User{Username: job.User, Password: job.Password, Mechanism: job.Mechanism}, conf.Auth, id)
+		if err != nil {
+			conn.close()
+			result.Err = err
+			results <- result
+			continue
+		}
+
+		glog.V(2).Info("LOGIN successful, user: ", job.User, " pw: ", conf.Output.Redact(job.Password))
+
+		result.Messages, result.Err = conn.seedMailbox(id, conf, job)
+
+		conn.logout(id)
+
+		results <- result
+	}
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"encoding/json"
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/golang/glog"
+)
+
+// Structs
+
+// SoakResult is the outcome of one connection of a soak run.
+// Output is its entry in the results log, Opened and Closed
+// are the times in nanoseconds it was logged in and closed.
+// Opened is zero if the connection could not be established,
+// Dropped is set if it failed while being kept alive. Connect
+// and Login are the latencies of establishing the connection
+// and Noops the keepalive NOOPs sent, all in nanoseconds.
+type SoakResult struct {
+	Output  []string
+	Opened  int64
+	Closed  int64
+	Dropped bool
+	Connect int64
+	Login   int64
+	Noops   []SoakCommand
+}
+
+// SoakCommand is a command a soak connection sent at
+// Sent, answered after RespTime nanoseconds.
+type SoakCommand struct {
+	Sent     int64
+	RespTime int64
+}
+
+// Functions
+
+// keepAliveNoop sends NOOP every KeepAlive seconds until stop
+// is closed. The first NOOP is sent after a random share of
+// the interval, so that connections do not send in bursts.
+// It returns all NOOPs sent.
+func (c *Conn) keepAliveNoop(id int, conf *config.Config, stop <-chan struct{}) ([]SoakCommand, error) {
+
+	interval := time.Duration(conf.Soak.KeepAlive) * time.Second
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
+	defer timer.Stop()
+
+	var noops []SoakCommand
+
+	for i := 1; ; i++ {
+
+		select {
+		case <-stop:
+			return noops, nil
+		case <-timer.C:
+		}
+
+		nanos := time.Now().UnixNano()
+
+		_, err := c.sendCommand(fmt.Sprintf("%dK%d", id, i), "NOOP")
+		if err != nil {
+			return noops, err
+		}
+
+		noops = append(noops, SoakCommand{
+			Sent:     nanos,
+			RespTime: (time.Now().UnixNano() - nanos),
+		})
+
+		timer.Reset(interval)
+	}
+}
+
+// keepAliveIdle waits in IDLE on INBOX and renews it every
+// KeepAlive seconds until stop is closed.
+func (c *Conn) keepAliveIdle(id int, conf *config.Config, stop <-chan struct{}) error {
+
+	if !c.Supports("IDLE") {
+		return fmt.Errorf("server does not advertise IDLE")
+	}
+
+	_, err := c.sendCommand(fmt.Sprintf("%dK0", id), "SELECT INBOX")
+	if err != nil {
+		return err
+	}
+
+	renew := time.Duration(conf.Soak.KeepAlive) * time.Second
+
+	for i := 1; ; i++ {
+
+		stopped, err := c.idle(fmt.Sprintf("%dK%d", id, i), stop, renew, func() {}, func(string) {})
+		if err != nil {
+			return err
+		}
+
+		if stopped {
+			return nil
+		}
+	}
+}
+
+// SoakConn opens the connection of the supplied id to addr,
+// logs in user and keeps the connection alive until stop is
+// closed. Connect and login latencies, the keepalive NOOPs
+// and failures are written to results.
+func SoakConn(id int, conf *config.Config, addr string, user config.User, stop <-chan struct{}, results chan<- SoakResult) {
+
+	var output []string
+
+	start := time.Now()
+
+	output = append(output, fmt.Sprintf("{\"SessionID\":%d,", id))
+	// User names may contain characters
+	// that have to be escaped in JSON.
+	jsonUser, _ := json.Marshal(user.Username)
+	output = append(output, fmt.Sprintf("\"User\":%s,", jsonUser))
+	output = append(output, fmt.Sprintf("\"Endpoint\":\"%s\",", addr))
+	output = append(output, fmt.Sprintf("\"Start\":%d,", start.UnixNano()))
+
+	result := SoakResult{}
+
+	// finish completes the log entry of the
+	// connection, recording err if it failed.
+	finish := func(err error) {
+
+		result.Closed = time.Now().UnixNano()
+		output = append(output, fmt.Sprintf(",\"Closed\":%d", result.Closed))
+
+		if err != nil {
+
+			jsonErr, _ := json.Marshal(err.Error())
+			output = append(output, fmt.Sprintf(",\"Error\":%s", jsonErr))
+		}
+
+		output = append(output, "}")
+		result.Output = output
+
+		results <- result
+	}
+
+	conn, err := dial(addr)
+	if err != nil {
+
+		glog.Warningf("Soak connection %d failed to connect: %v", id, err)
+		metrics.ConnectionFailures.WithLabelValues("connect").Inc()
+
+		output = append(output, "\"Commands\":[]")
+		finish(err)
+
+		return
+	}
+
+	connectTime := time.Since(start)
+	result.Connect = connectTime.Nanoseconds()
+
+	// A server that stops answering must not keep the
+	// run waiting for the connection forever.
+	conn.c.SetDeadline(time.Now().Add(dialTimeout))
+
+	err = conn.login(user, conf.Auth, id)
+	if err != nil {
+
+		glog.Warningf("Soak connection %d failed to log in: %v", id, err)
+		metrics.ConnectionFailures.WithLabelValues("login").Inc()
+		conn.close()
+
+		output = append(output, fmt.Sprintf("\"Connect\":%d,\"Commands\":[]", connectTime.Nanoseconds()))
+		finish(err)
+
+		return
+	}
+
+	conn.c.SetDeadline(time.Time{})
+
+	loginTime := time.Since(start) - connectTime
+	result.Opened = time.Now().UnixNano()
+	result.Login = loginTime.Nanoseconds()
+
+	output = append(output, fmt.Sprintf("\"Connect\":%d,\"Login\":%d,", connectTime.Nanoseconds(), loginTime.Nanoseconds()))
+
+	if conf.Soak.Idle {
+		err = conn.keepAliveIdle(id, conf, stop)
+	} else {
+		result.Noops, err = conn.keepAliveNoop(id, conf, stop)
+	}
+
+	var commandlog []string
+	for _, noop := range result.Noops {
+		commandlog = append(commandlog, fmt.Sprintf("[%d,\"NOOP\",%d]", noop.Sent, noop.RespTime))
+	}
+
+	output = append(output, fmt.Sprintf("\"Commands\":[%s]", strings.Join(commandlog, ",")))
+
+	if err != nil {
+
+		glog.Warningf("Soak connection %d dropped: %v", id, err)
+		conn.close()
+
+		result.Dropped = true
+		finish(err)
+
+		return
+	}
+
+	conn.logout(id)
+	finish(nil)
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+
+	"math/rand"
+
+	"github.com/go-pluto/benchmark/config"
+)
+
+// Structs
+
+// UserSelector decides which user runs the next session.
+// Users are picked uniformly at random, in round-robin
+// order, or Zipf distributed so that users early in the
+// userdb are "hot". In exclusive mode, a user is only
+// handed out again after its previous session has been
+// released, so no user has two concurrent sessions.
+type UserSelector struct {
+	lock      *sync.Mutex
+	free      *sync.Cond
+	users     []config.User
+	pick      func() int
+	exclusive bool
+	busy      map[int]bool
+	indices   map[string]int
+}
+
+// Functions
+
+// NewUserSelector creates a UserSelector for users
+// according to the configured selection strategy.
+func NewUserSelector(conf *config.Config, users []config.User) (*UserSelector, error) {
+
+	lock := &sync.Mutex{}
+
+	s := &UserSelector{
+		lock:      lock,
+		free:      sync.NewCond(lock),
+		users:     users,
+		exclusive: conf.Settings.ExclusiveUsers,
+		busy:      make(map[int]bool),
+		indices:   make(map[string]int),
+	}
+
+	for i, user := range users {
+		s.indices[user.Username] = i
+	}
+
+	switch conf.Settings.UserSelection {
+	case "", "uniform":
+
+		s.pick = func() int {
+			return rand.Intn(len(users))
+		}
+
+	case "roundrobin":
+
+		next := 0
+		s.pick = func() int {
+			i := next
+			next = (next + 1) % len(users)
+			return i
+		}
+
+	case "zipf":
+
+		exponent := conf.Settings.ZipfS
+		if exponent == 0 {
+			exponent = 1.1
+		}
+
+		if exponent <= 1 {
+			return nil, fmt.Errorf("zipf exponent has to be greater than 1 but is %f", exponent)
+		}
+
+		// Derive the Zipf source from the seeded
+		// global source to stay reproducible.
+		zipf := rand.NewZipf(rand.New(rand.NewSource(rand.Int63())), exponent, 1, uint64(len(users)-1))
+		s.pick = func() int {
+			return int(zipf.Uint64())
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown user selection '%s', choose uniform, roundrobin or zipf", conf.Settings.UserSelection)
+	}
+
+	return s, nil
+}
+
+// Acquire returns the user to run the next session. In
+// exclusive mode it blocks until a user is available.
+func (s *UserSelector) Acquire() config.User {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.exclusive {
+		return s.users[s.pick()]
+	}
+
+	for len(s.busy) == len(s.users) {
+		s.free.Wait()
+	}
+
+	// Ask the strategy until it picks a free user. As
+	// skewed strategies may rarely pick the remaining
+	// free users, fall back to the first free one after
+	// as many attempts as there are users.
+	i := s.pick()
+	for attempts := 1; s.busy[i]; attempts++ {
+
+		if attempts < len(s.users) {
+			i = s.pick()
+		} else {
+			i = (i + 1) % len(s.users)
+		}
+	}
+
+	s.busy[i] = true
+
+	return s.users[i]
+}
+
+// Release marks the session of user as finished
+// so the user can be acquired again.
+func (s *UserSelector) Release(user config.User) {
+
+	if !s.exclusive {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.busy, s.indices[user.Username])
+	s.free.Signal()
+}
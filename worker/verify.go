@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
+	"github.com/go-pluto/benchmark/sessions"
+	"github.com/golang/glog"
+)
+
+// Structs
+
+// Verifier collects the mailbox state expected from all
+// finished sessions per user and checks it against the
+// state on the server at the end of a run.
+type Verifier struct {
+	lock     sync.Mutex
+	users    map[string]config.User
+	expected map[string]map[string]sessions.Folder
+	deleted  map[string]map[string]bool
+}
+
+// Functions
+
+// NewVerifier creates a Verifier without recorded state.
+func NewVerifier() *Verifier {
+
+	return &Verifier{
+		users:    make(map[string]config.User),
+		expected: make(map[string]map[string]sessions.Folder),
+		deleted:  make(map[string]map[string]bool),
+	}
+}
+
+// serverState translates the mailbox model of a session to
+// the folder names used on the server. It also returns the
+// server names of all folders deleted or renamed by commands
+// that do not exist in the model anymore.
+func serverState(model []sessions.Folder, commands [][]sessions.IMAPCommand, prefix string, existing map[string]bool) ([]sessions.Folder, []string) {
+
+	expected := make([]sessions.Folder, 0, len(model))
+	remaining := make(map[string]bool)
+
+	for _, folder := range model {
+
+		remaining[folder.FolderName] = true
+
+		expected = append(expected, sessions.Folder{
+			FolderName: folderName(folder.FolderName, prefix, existing),
+			Messages:   folder.Messages,
+		})
+	}
+
+	var deleted []string
+
+	for _, sequence := range commands {
+
+		for _, command := range sequence {
+
+			if ((command.Command == "DELETE") || (command.Command == "RENAME")) && !remaining[command.Arguments[0]] {
+				deleted = append(deleted, folderName(command.Arguments[0], prefix, existing))
+			}
+		}
+	}
+
+	return expected, deleted
+}
+
+// verifySession fetches the state of the mailbox of the
+// logged in user and compares it with the expected state.
+// Divergences are counted and returned.
+func (c *Conn) verifySession(id int, expected []sessions.Folder, deleted []string) ([]string, error) {
+
+	actual, err := c.discover(id)
+	if err != nil {
+		return nil, err
+	}
+
+	divergences := sessions.Diff(expected, actual, deleted)
+	metrics.Divergences.Add(float64(len(divergences)))
+
+	return divergences, nil
+}
+
+// record merges the state expected after a session of user
+// into the state expected at the end of the run. Sessions of
+// the same user must not overlap, which LoadConfig ensures by
+// requiring exclusive users. Devices of a user group are
+// recorded at once by the last of them to finish.
+func (v *Verifier) record(user config.User, expected []sessions.Folder, deleted []string) {
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, ok := v.users[user.Username]; !ok {
+		v.users[user.Username] = user
+		v.expected[user.Username] = make(map[string]sessions.Folder)
+		v.deleted[user.Username] = make(map[string]bool)
+	}
+
+	for _, name := range deleted {
+		delete(v.expected[user.Username], name)
+		v.deleted[user.Username][name] = true
+	}
+
+	for _, folder := range expected {
+		v.expected[user.Username][folder.FolderName] = folder
+		delete(v.deleted[user.Username], folder.FolderName)
+	}
+}
+
+// verifyUser compares the mailbox of user on the
+// server with the state recorded for the user.
+func (v *Verifier) verifyUser(id int, conf *config.Config, user config.User) ([]string, error) {
+
+	v.lock.Lock()
+
+	var expected []sessions.Folder
+	for _, folder := range v.expected[user.Username] {
+		expected = append(expected, folder)
+	}
+
+	var deleted []string
+	for name := range v.deleted[user.Username] {
+		deleted = append(deleted, name)
+	}
+
+	v.lock.Unlock()
+
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].FolderName < expected[j].FolderName
+	})
+	sort.Strings(deleted)
+
+	conn, err := dial(conf.Server.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to remote server %s: %v", conf.Server.Addr, err)
+	}
+
+	err = conn.login(user, conf.Auth, id)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	divergences, err := conn.verifySession(id, expected, deleted)
+
+	conn.logout(id)
+
+	return divergences, err
+}
+
+// Verify checks the mailboxes of all users with recorded
+// state on the server, using the configured number of
+// threads. Divergences are returned prefixed by the user.
+func (v *Verifier) Verify(conf *config.Config) []string {
+
+	v.lock.Lock()
+
+	var users []config.User
+	for _, user := range v.users {
+		users = append(users, user)
+	}
+
+	v.lock.Unlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	jobs := make(chan int, len(users))
+	for i := range users {
+		jobs <- i
+	}
+	close(jobs)
+
+	found := make([][]string, len(users))
+
+	var wg sync.WaitGroup
+
+	for w := 1; w <= conf.Settings.Threads; w++ {
+
+		wg.Add(1)
+
+		go func(id int) {
+
+			defer wg.Done()
+
+			for i := range jobs {
+
+				divergences, err := v.verifyUser(id, conf, users[i])
+				if err != nil {
+					divergences = []string{fmt.Sprintf("verification failed: %v", err)}
+				}
+
+				for _, divergence := range divergences {
+					found[i] = append(found[i], fmt.Sprintf("%s: %s", users[i].Username, divergence))
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	divergences := make([]string, 0)
+	for i := range found {
+		divergences = append(divergences, found[i]...)
+	}
+
+	glog.Infof("Verified mailboxes of %d users, found %d divergences", len(users), len(divergences))
+
+	return divergences
+}
@@ -1,15 +1,16 @@
 package worker
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
-	"crypto/tls"
+	"encoding/json"
 
 	"github.com/go-pluto/benchmark/config"
+	"github.com/go-pluto/benchmark/metrics"
 	"github.com/go-pluto/benchmark/sessions"
 	"github.com/golang/glog"
 )
@@ -18,62 +19,501 @@ import (
 
 // Session contains the user's credentials, an identifier for the
 // session and a sequence of IMAP commands that has been generated
-// by the sessions package.
+// by the sessions package. Sessions that simulate several devices
+// of the same user concurrently belong to a user group identified
+// by Group, Device numbers them within the group. Endpoint is
+// the address the session is sent to, model is the expected
+// state of the mailbox after the session. Mechanism is the
+// SASL mechanism of the user, if any.
 type Session struct {
-	User     string
-	Password string
-	ID       int
-	Commands []sessions.IMAPCommand
+	User      string
+	Password  string
+	Mechanism string
+	ID        int
+	Group     int
+	Device    int
+	Endpoint  string
+	Commands  []sessions.IMAPCommand
+	model     []sessions.Folder
+	group     *userGroup
+}
+
+// userGroup synchronizes the sessions of all devices
+// of a user group. start makes them begin sending
+// commands at the same time, remaining counts the
+// devices that have not yet finished. step is the
+// next step of the shared model to be sent, turn
+// wakes devices waiting for it. commands holds
+// the command sequences of all devices and model the
+// expected state of the mailbox after all of them,
+// existing the folders discovered in the mailbox before
+// generating them, generate makes sure this happens once.
+type userGroup struct {
+	start     sync.WaitGroup
+	lock      sync.Mutex
+	remaining int
+	devices   int
+	step      int
+	turn      *sync.Cond
+	generate  sync.Once
+	commands  [][]sessions.IMAPCommand
+	model     []sessions.Folder
+	existing  map[string]bool
 }
 
 // Functions
 
+// newUserGroup creates a userGroup of the supplied size.
+func newUserGroup(devices int) *userGroup {
+
+	g := &userGroup{
+		remaining: devices,
+		devices:   devices,
+	}
+	g.start.Add(devices)
+	g.turn = sync.NewCond(&g.lock)
+
+	return g
+}
+
+// await blocks until step is the next step of the shared
+// model, so that the devices of g send their commands in
+// the order they were generated in. Sessions without a
+// group do not wait.
+func (g *userGroup) await(step int) {
+
+	if g == nil {
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for g.step != step {
+		g.turn.Wait()
+	}
+}
+
+// advance marks the next n steps of the shared model
+// as sent and wakes the device that sends the next one.
+func (g *userGroup) advance(n int) {
+
+	if g == nil {
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.step += n
+	g.turn.Broadcast()
+}
+
+// finish marks one device of the group as finished and
+// reports whether it was the last one to finish.
+func (g *userGroup) finish() bool {
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.remaining--
+
+	return g.remaining == 0
+}
+
+// folderPrefix returns the prefix of all folder names
+// used in session when run by worker id. Devices of a
+// user group share their folders, other sessions use
+// folders specific to the worker.
+func folderPrefix(session Session, id int) string {
+
+	if session.Group != 0 {
+		return fmt.Sprintf("%dG", session.Group)
+	}
+
+	return fmt.Sprintf("%dX", id)
+}
+
+// folderNames returns the set of names of folders.
+func folderNames(folders []sessions.Folder) map[string]bool {
+
+	names := make(map[string]bool)
+	for _, folder := range folders {
+		names[folder.FolderName] = true
+	}
+
+	return names
+}
+
+// folderName returns the name of folder on the server.
+// Folders that existed in the mailbox before the session
+// and INBOX keep their name, folders generated for the
+// session are prefixed with prefix.
+func folderName(folder string, prefix string, existing map[string]bool) string {
+
+	if (folder == "INBOX") || existing[folder] {
+		return folder
+	}
+
+	return fmt.Sprintf("%s%s", prefix, folder)
+}
+
+// mailboxName returns the name of folder on the
+// server as used in commands, see folderName.
+func mailboxName(folder string, prefix string, existing map[string]bool) string {
+	return quoteString(folderName(folder, prefix, existing))
+}
+
+// commandLine returns the line sent for a CREATE, DELETE,
+// RENAME, SUBSCRIBE, UNSUBSCRIBE or STORE command with
+// index i of worker id.
+func commandLine(id int, i int, command sessions.IMAPCommand, prefix string, existing map[string]bool) string {
+
+	if command.Command == "STORE" {
+		return fmt.Sprintf("%dX%d STORE %s FLAGS %s", id, i, command.Arguments[0], command.Arguments[1])
+	}
+
+	if command.Command == "RENAME" {
+		return fmt.Sprintf("%dX%d RENAME %s %s", id, i, mailboxName(command.Arguments[0], prefix, existing), mailboxName(command.Arguments[1], prefix, existing))
+	}
+
+	return fmt.Sprintf("%dX%d %s %s", id, i, command.Command, mailboxName(command.Arguments[0], prefix, existing))
+}
+
+// pipelinable reports whether command can be sent while
+// the commands of batch are still in flight. This holds
+// for STOREs, which neither change message numbers nor
+// the selected folder, and for CREATEs and DELETEs of
+// folders no other command of the batch refers to, neither
+// directly nor via an ancestor or inferior.
+func pipelinable(batch []sessions.IMAPCommand, command sessions.IMAPCommand) bool {
+
+	switch command.Command {
+
+	case "STORE":
+		return true
+
+	case "CREATE", "DELETE":
+
+		for _, other := range batch {
+
+			// Generated names of nested folders extend
+			// the names of their ancestors.
+			if (other.Command != "STORE") && (strings.HasPrefix(other.Arguments[0], command.Arguments[0]) || strings.HasPrefix(command.Arguments[0], other.Arguments[0])) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// configUser returns the user that runs session.
+func configUser(session Session) config.User {
+
+	return config.User{
+		Username:  session.User,
+		Password:  session.Password,
+		Mechanism: session.Mechanism,
+	}
+}
+
 // Worker is the routine that sends the commands of the session
 // to the server. The output will be logged and written in
-// the logger channel.
-func Worker(id int, config *config.Config, jobs <-chan Session, logger chan<- []string) {
+// the logger channel. Finished sessions' users are released
+// to the selector. If configured, the mailbox is verified
+// after each session and its expected state is recorded
+// in verifier. Completed appends are reported to watcher.
+func Worker(id int, config *config.Config, jobs <-chan Session, logger chan<- []string, selector *UserSelector, verifier *Verifier, watcher *IdleWatcher) {
+
+	pool := newConnPool(config)
 
-	for job := range jobs {
+	// Persistent connections are kept alive
+	// while waiting for the next session.
+	var keepAlive <-chan time.Time
+	if config.Connection.Mode == "persistent" {
+
+		ticker := time.NewTicker(time.Duration(config.Connection.KeepAlive) * time.Second)
+		defer ticker.Stop()
+
+		keepAlive = ticker.C
+	}
+
+	for {
+
+		var job Session
+
+		select {
+		case <-keepAlive:
+			pool.keepAlive(id)
+			continue
+		case next, ok := <-jobs:
+
+			if !ok {
+				pool.close(id)
+				return
+			}
+
+			job = next
+		}
 
 		var output []string
 
 		output = append(output, fmt.Sprintf("{\"SessionID\":%d,", job.ID))
 		output = append(output, fmt.Sprintf("\"User\":\"%s\",", job.User))
-		output = append(output, fmt.Sprintf("\"Password\":\"%s\",", job.Password))
-		output = append(output, "\"Commands\":[")
+		output = append(output, fmt.Sprintf("\"Endpoint\":\"%s\",", job.Endpoint))
+
+		if job.Group != 0 {
+			output = append(output, fmt.Sprintf("\"Group\":%d,\"Device\":%d,", job.Group, job.Device))
+		}
 
-		// Connect to remote server.
-		tlsConn, err := tls.Dial("tcp", config.Server.Addr, &tls.Config{
-			InsecureSkipVerify: true,
-		})
-		if err != nil {
-			log.Fatalf("Unable to connect to remote server %s: %v", config.Server.Addr, err)
+		if !config.Output.Omit() {
+			output = append(output, fmt.Sprintf("\"Password\":\"%s\",", config.Output.Redact(job.Password)))
 		}
 
-		conn := &Conn{
-			c: tlsConn,
-			r: bufio.NewReader(tlsConn),
+		sessionStart := time.Now()
+
+		// Reuse an authenticated connection of the user
+		// if one is kept in the pool, or take over the one
+		// of another user by logging in again.
+		conn, switched := pool.get(job.Endpoint, job.User, id)
+
+		if (conn != nil) && !switched {
+			output = append(output, "\"Reused\":true,")
+		} else {
+
+			var err error
+			var connectTime time.Duration
+
+			if switched {
+				err = conn.relogin(configUser(job), config.Auth, id)
+			} else {
+
+				// Connect to remote server.
+				conn, err = dial(job.Endpoint)
+				if err != nil {
+					log.Fatalf("Unable to connect to remote server %s: %v", job.Endpoint, err)
+				}
+
+				connectTime = time.Since(sessionStart)
+
+				// Login user for following IMAP commands session.
+				err = conn.login(configUser(job), config.Auth, id)
+			}
+
+			if err != nil {
+				metrics.ConnectionFailures.WithLabelValues("login").Inc()
+				log.Fatalf("Unable to log in user %s: %v", job.User, err)
+			}
+			glog.V(2).Info("LOGIN successful, user: ", job.User, " pw: ", config.Output.Redact(job.Password))
+
+			loginTime := time.Since(sessionStart) - connectTime
+
+			if switched {
+				metrics.ConnectionSwitches.Inc()
+				output = append(output, fmt.Sprintf("\"Reused\":true,\"Switched\":true,\"Login\":%d,", loginTime.Nanoseconds()))
+			} else {
+				output = append(output, fmt.Sprintf("\"Connect\":%d,\"Login\":%d,", connectTime.Nanoseconds(), loginTime.Nanoseconds()))
+			}
+
+			// Turning on extensions, including a CAPABILITY
+			// command if needed, is timed on its own. Switching
+			// users turns off all extensions enabled before.
+			enableStart := time.Now()
+
+			enabled, err := conn.enable(id, config.Session.Enable)
+			if err != nil {
+				glog.Warningf("Unable to enable %v for user %s: %v", config.Session.Enable, job.User, err)
+			} else if len(enabled) > 0 {
+				glog.V(2).Infof("Enabled %v for user %s", enabled, job.User)
+				output = append(output, fmt.Sprintf("\"Enable\":%d,", time.Since(enableStart).Nanoseconds()))
+			}
 		}
 
-		// Login user for following IMAP commands session.
-		conn.login(job.User, job.Password, id)
-		glog.V(2).Info("LOGIN successful, user: ", job.User, " pw: ", job.Password)
+		output = append(output, "\"Commands\":[")
+
+		commands := job.Commands
+		model := job.model
+		existing := make(map[string]bool)
+
+		if job.group != nil {
+
+			// When discovering the mailbox, the first device
+			// of a group to log in generates the commands of
+			// all devices based on the discovered folders.
+			if config.Session.Discover {
+
+				job.group.generate.Do(func() {
+
+					folders, err := conn.discover(id)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					opts, err := discoverOptions(conn, id, config)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					job.group.existing = folderNames(folders)
+					job.group.commands, job.group.model = sessions.GenerateSessions(folders, job.group.devices, opts)
+				})
+
+				existing = job.group.existing
+			}
+
+			commands = job.group.commands[(job.Device - 1)]
+			model = job.group.model
+
+			// Devices of a user group wait for each other
+			// in order to send their commands concurrently.
+			job.group.start.Done()
+			job.group.start.Wait()
+		} else if config.Session.Discover {
+
+			folders, err := conn.discover(id)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			opts, err := discoverOptions(conn, id, config)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			existing = folderNames(folders)
+
+			var sequences [][]sessions.IMAPCommand
+			sequences, model = sessions.GenerateSessions(folders, 1, opts)
+			commands = sequences[0]
+		}
+
+		prefix := folderPrefix(job, id)
+
+		// Poll a probe replica for the effect of
+		// every write if replicas are configured.
+		var replica *probe
+		if len(config.Server.Probes()) > 0 {
+
+			var err error
+			replica, err = dialProbe(id, config, configUser(job))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 
 		var commandlog []string
+		selected := ""
+		numPipelined := 0
+		var appendLiterals []string
+		literals := conn.literalMode(config.Session.Literals == "auto")
+
+		// converge hands a write sent at nanos to the
+		// probe, which measures the time until it becomes
+		// visible on the replica in the background.
+		converge := func(command sessions.IMAPCommand, status string, nanos int64) {
+
+			completed := time.Now()
+
+			if (replica == nil) || (status != "OK") || !writeCommands[command.Command] {
+				return
+			}
+
+			mailbox := ""
+			if (command.Command == "CREATE") || (command.Command == "DELETE") || (command.Command == "APPEND") {
+				mailbox = folderName(command.Arguments[0], prefix, existing)
+			}
+
+			// COPY, MOVE and RENAME are observed in the destination.
+			if strings.HasSuffix(command.Command, "COPY") || strings.HasSuffix(command.Command, "MOVE") || (command.Command == "RENAME") {
+				mailbox = folderName(command.Arguments[1], prefix, existing)
+			}
+
+			source := ""
+			if command.Command == "RENAME" {
+				source = folderName(command.Arguments[0], prefix, existing)
+			}
+
+			replica.submit(write{
+				command:   command,
+				mailbox:   mailbox,
+				source:    source,
+				selected:  selected,
+				sent:      nanos,
+				completed: completed,
+			})
+		}
+
+		for i := 0; i < len(commands); i++ {
+
+			// Send runs of independent commands at
+			// once if pipelining is configured.
+			if config.Session.Pipeline > 1 {
+
+				// Devices of a group only pipeline commands
+				// that follow each other in the shared order.
+				// Commands waiting for a continuation request
+				// are sent on their own.
+				consecutive := func(j int) bool {
+					return (job.group == nil) || (j == i) || (commands[j].Step == (commands[(j-1)].Step + 1))
+				}
+
+				independent := func(j int) bool {
+					return !conn.synchronizing(commandLine(id, j, commands[j], prefix, existing))
+				}
+
+				end := i
+				for (end < len(commands)) && ((end - i) < config.Session.Pipeline) && pipelinable(commands[i:end], commands[end]) && consecutive(end) && independent(end) {
+					end++
+				}
+
+				if (end - i) > 1 {
+
+					var lines []string
+					for j := i; j < end; j++ {
+						lines = append(lines, commandLine(id, j, commands[j], prefix, existing))
+					}
+
+					glog.V(2).Info("Sending ", len(lines), " commands pipelined")
+
+					job.group.await(commands[i].Step)
 
-		for i := 0; i < len(job.Commands); i++ {
+					results, err := conn.sendPipelined(lines)
+					if err != nil {
+						log.Fatal(err)
+					}
 
-			glog.V(2).Info("Sending ", job.Commands[i].Command)
+					for j, result := range results {
+						commandlog = append(commandlog, fmt.Sprintf("[%d,\"%s\",%d]", result.sent, commands[i+j].Command, result.respTime))
+						converge(commands[i+j], result.status, result.sent)
+					}
+
+					job.group.advance(len(lines))
+
+					numPipelined += len(lines)
+					i = end - 1
+
+					continue
+				}
+			}
+
+			glog.V(2).Info("Sending ", commands[i].Command)
+
+			// Devices of a group wait for the commands
+			// generated before this one to be sent.
+			job.group.await(commands[i].Step)
 
 			nanos := time.Now().UnixNano()
 
-			switch job.Commands[i].Command {
+			switch commands[i].Command {
 
 			case "CREATE":
 
-				command := fmt.Sprintf("%dX%d CREATE %dX%s", id, i, id, job.Commands[i].Arguments[0])
+				command := commandLine(id, i, commands[i], prefix, existing)
 
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -82,60 +522,113 @@ func Worker(id int, config *config.Config, jobs <-chan Session, logger chan<- []
 
 			case "DELETE":
 
-				command := fmt.Sprintf("%dX%d DELETE %dX%s", id, i, id, job.Commands[i].Arguments[0])
+				command := commandLine(id, i, commands[i], prefix, existing)
 
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
 
 				commandlog = append(commandlog, fmt.Sprintf("[%d,\"DELETE\",%d]", nanos, respTime))
 
+			case "RENAME", "SUBSCRIBE", "UNSUBSCRIBE":
+
+				command := commandLine(id, i, commands[i], prefix, existing)
+
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				commandlog = append(commandlog, fmt.Sprintf("[%d,\"%s\",%d]", nanos, commands[i].Command, respTime))
+
 			case "APPEND":
 
-				// command := fmt.Sprintf("%dX%d APPEND %dX%s %s %s", id, i, id, job.Commands[i].Arguments[0], job.Commands[i].Arguments[1], job.Commands[i].Arguments[2])
-				command := fmt.Sprintf("%dX%d APPEND %dX%s %s", id, i, id, job.Commands[i].Arguments[0], job.Commands[i].Arguments[2])
+				// command := fmt.Sprintf("%dX%d APPEND %s%s %s %s", id, i, prefix, commands[i].Arguments[0], commands[i].Arguments[1], commands[i].Arguments[2])
+				command := fmt.Sprintf("%dX%d APPEND %s %s", id, i, mailboxName(commands[i].Arguments[0], prefix, existing), commands[i].Arguments[2])
 
-				respTime, err := conn.sendAppendCommand(command, job.Commands[i].Arguments[3])
+				respTime, used, err := conn.sendAppendCommand(command, commands[i].Arguments[3], literals)
 				if err != nil {
 					log.Fatal(err)
 				}
 
+				appendLiterals = append(appendLiterals, fmt.Sprintf("\"%s\"", used))
+
+				if conn.status == "OK" {
+					watcher.appended(job.User, folderName(commands[i].Arguments[0], prefix, existing), nanos)
+				}
+
 				commandlog = append(commandlog, fmt.Sprintf("[%d,\"APPEND\",%d]", nanos, respTime))
 
 			case "SELECT":
 
-				var command string
+				command := fmt.Sprintf("%dX%d SELECT %s", id, i, mailboxName(commands[i].Arguments[0], prefix, existing))
 
-				if job.Commands[i].Arguments[0] == "INBOX" {
-					command = fmt.Sprintf("%dX%d SELECT %s", id, i, job.Commands[i].Arguments[0])
-				} else {
-					command = fmt.Sprintf("%dX%d SELECT %dX%s", id, i, id, job.Commands[i].Arguments[0])
-				}
-
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
 
+				if conn.status == "OK" {
+					selected = folderName(commands[i].Arguments[0], prefix, existing)
+				}
+
 				commandlog = append(commandlog, fmt.Sprintf("[%d,\"SELECT\",%d]", nanos, respTime))
 
 			case "STORE":
 
-				command := fmt.Sprintf("%dX%d STORE %s FLAGS %s", id, i, job.Commands[i].Arguments[0], job.Commands[i].Arguments[1])
+				command := commandLine(id, i, commands[i], prefix, existing)
 
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
 
 				commandlog = append(commandlog, fmt.Sprintf("[%d,\"STORE\",%d]", nanos, respTime))
 
+			case "COPY", "MOVE", "UID COPY", "UID MOVE":
+
+				// UID commands refer to the message by the
+				// UID the server assigned, which is fetched
+				// beforehand without being measured.
+				message := commands[i].Arguments[0]
+				if strings.HasPrefix(commands[i].Command, "UID ") {
+
+					var err error
+					message, err = conn.uid(fmt.Sprintf("%dU%d", id, i), message)
+					if (err != nil) && (conn.status == "") {
+						log.Fatal(err)
+					}
+
+					// Other devices of the user group may
+					// have expunged the message meanwhile.
+					if err != nil {
+						glog.Warningf("Skipping %s of message %s: %v", commands[i].Command, commands[i].Arguments[0], err)
+						conn.status = "NO"
+						break
+					}
+
+					nanos = time.Now().UnixNano()
+				}
+
+				command := fmt.Sprintf("%dX%d %s %s %s", id, i, commands[i].Command, message, mailboxName(commands[i].Arguments[1], prefix, existing))
+
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if conn.status == "OK" {
+					watcher.appended(job.User, folderName(commands[i].Arguments[1], prefix, existing), nanos)
+				}
+
+				commandlog = append(commandlog, fmt.Sprintf("[%d,\"%s\",%d]", nanos, commands[i].Command, respTime))
+
 			case "EXPUNGE":
 
 				command := fmt.Sprintf("%dX%d EXPUNGE", id, i)
 
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -146,7 +639,7 @@ func Worker(id int, config *config.Config, jobs <-chan Session, logger chan<- []
 
 				command := fmt.Sprintf("%dX%d CLOSE", id, i)
 
-				respTime, err := conn.sendSimpleCommand(command)
+				respTime, err := conn.sendSimpleCommand(commands[i].Command, command)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -154,14 +647,81 @@ func Worker(id int, config *config.Config, jobs <-chan Session, logger chan<- []
 				commandlog = append(commandlog, fmt.Sprintf("[%d,\"CLOSE\",%d]", nanos, respTime))
 			}
 
-			glog.V(2).Info(job.Commands[i].Command, " finished.")
+			converge(commands[i], conn.status, nanos)
+			job.group.advance(1)
+
+			glog.V(2).Info(commands[i].Command, " finished.")
 		}
 
 		output = append(output, strings.Join(commandlog, ","))
-		output = append(output, "]}")
+		output = append(output, "]")
+		output = append(output, fmt.Sprintf(",\"Literals\":\"%s\"", literals))
+
+		if len(appendLiterals) > 0 {
+			output = append(output, fmt.Sprintf(",\"AppendLiterals\":[%s]", strings.Join(appendLiterals, ",")))
+		}
+
+		if config.Session.Pipeline > 1 {
+			output = append(output, fmt.Sprintf(",\"Pipelined\":%d", numPipelined))
+		}
+
+		// The last device of a group to finish verifies
+		// the mailbox for the whole group.
+		last := (job.group == nil) || job.group.finish()
+
+		if last && (config.Verify.Sessions || config.Verify.Run) {
+
+			sequences := [][]sessions.IMAPCommand{commands}
+			if job.group != nil {
+				sequences = job.group.commands
+			}
+
+			expected, deleted := serverState(model, sequences, prefix, existing)
+
+			if config.Verify.Sessions {
+
+				divergences, err := conn.verifySession(id, expected, deleted)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				for _, divergence := range divergences {
+					glog.Warningf("Session %d of user %s diverged: %s", job.ID, job.User, divergence)
+				}
+
+				jsonDivergences, err := json.Marshal(divergences)
+				if err != nil {
+					log.Fatal(err)
+				}
 
-		conn.logout(id)
-		glog.V(2).Info("LOGOUT successful, user: ", job.User, " pw: ", job.Password)
+				output = append(output, fmt.Sprintf(",\"Divergences\":%s", jsonDivergences))
+			}
+
+			if config.Verify.Run {
+				verifier.record(configUser(job), expected, deleted)
+			}
+		}
+
+		if replica != nil {
+
+			output = append(output, fmt.Sprintf(",\"Convergence\":[%s]", strings.Join(replica.finish(), ",")))
+
+			replica.conn.logout(id)
+		}
+
+		output = append(output, "}")
+
+		// Keep the connection for the next session of
+		// the user if configured, log out otherwise.
+		pool.put(job.Endpoint, job.User, conn, id)
+
+		metrics.SessionDuration.Observe(time.Since(sessionStart).Seconds())
+
+		// The user of a group is released once
+		// all of its devices have finished.
+		if last {
+			selector.Release(configUser(job))
+		}
 
 		logger <- output
 	}